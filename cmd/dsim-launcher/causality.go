@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+)
+
+// causalityCommand checks the vector clocks of the logs of a run, exiting
+// with 1 when an event contradicts the order of the events it depends on
+func causalityCommand(args []string) int {
+	spec, err := defaultRunSpec()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	flags := flag.NewFlagSet("causality", flag.ExitOnError)
+	flags.StringVar(&spec.LogsDir, "logsDir", spec.LogsDir, "The directory of the launcher and node logs")
+	flags.Parse(args)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		if files, err = runLogFiles(spec.LogsDir, ""); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	var entries []clock.LogEntry
+	for _, file := range files {
+		fileEntries, err := readLogFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	violations := clock.CheckCausality(entries)
+	for _, v := range violations {
+		fmt.Println(v)
+	}
+	fmt.Printf("Checked %d events of %d logs: %d causality violations\n", len(entries), len(files), len(violations))
+	if len(violations) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// archPlaceholder is replaced by the host architecture in the binaries pattern
+const archPlaceholder = "{arch}"
+
+// unameArchs maps uname -m output to GOARCH values
+var unameArchs = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i686":    "386",
+	"i386":    "386",
+}
+
+// Deployer uploads the dsim-node build matching the architecture of every
+// host into a cache directory over SFTP, so nodes do not need the binary
+// beforehand. Hosts are deployed concurrently, nodes sharing a host wait for
+// its first deployment.
+type Deployer struct {
+	// Binaries is the local path of the dsim-node builds, {arch} is replaced
+	// by the GOARCH of the host, e.g. ./cmd/dsim-node/dsim-node-{arch}
+	Binaries string
+	// CacheDir is the remote directory holding the uploaded binaries,
+	// relative paths start at the home of the ssh user
+	CacheDir string
+
+	mutex sync.Mutex
+	hosts map[string]*hostDeployment
+}
+
+// hostDeployment is the binary deployed on a host, empty until a deployment
+// succeeds
+type hostDeployment struct {
+	mutex      sync.Mutex
+	remotePath string
+}
+
+// host returns the deployment of a host
+func (d *Deployer) host(host string) *hostDeployment {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.hosts == nil {
+		d.hosts = make(map[string]*hostDeployment)
+	}
+	h, ok := d.hosts[host]
+	if !ok {
+		h = &hostDeployment{}
+		d.hosts[host] = h
+	}
+	return h
+}
+
+// Deploy makes the right dsim-node build available on the host client
+// connects to and returns its remote path. Binaries already in the cache
+// with a matching checksum are not uploaded again.
+func (d *Deployer) Deploy(client *ssh.Client, host string) (string, error) {
+	h := d.host(host)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.remotePath != "" {
+		return h.remotePath, nil
+	}
+
+	uname, err := runOutput(client, "uname -m")
+	if err != nil {
+		return "", fmt.Errorf("failed to detect architecture of %s: %w", host, err)
+	}
+	arch, ok := unameArchs[strings.TrimSpace(uname)]
+	if !ok {
+		return "", fmt.Errorf("unsupported architecture %q on %s", strings.TrimSpace(uname), host)
+	}
+
+	localPath := strings.ReplaceAll(d.Binaries, archPlaceholder, arch)
+	binary, err := os.ReadFile(localPath)
+	if err != nil {
+		return "", fmt.Errorf("no dsim-node build for %s: %w", arch, err)
+	}
+	sum := sha256.Sum256(binary)
+	checksum := hex.EncodeToString(sum[:])
+	remotePath := path.Join(d.CacheDir, fmt.Sprintf("dsim-node-%s-%s", arch, checksum[:12]))
+
+	if remoteChecksum(client, remotePath) == checksum {
+		log.Printf("Using cached %s on %s", remotePath, host)
+	} else {
+		log.Printf("Uploading %s to %s:%s", localPath, host, remotePath)
+		if err := upload(client, remotePath, binary, checksum); err != nil {
+			return "", fmt.Errorf("failed to upload dsim-node to %s: %w", host, err)
+		}
+	}
+
+	h.remotePath = remotePath
+	return remotePath, nil
+}
+
+// upload writes binary to a temporary file next to remotePath over SFTP and
+// renames it into place once its checksum matches, so an interrupted upload
+// never leaves a truncated binary in the cache
+func upload(client *ssh.Client, remotePath string, binary []byte, checksum string) error {
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp: %w", err)
+	}
+	defer sftpClient.Close()
+
+	if err := sftpClient.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+	tmpPath := remotePath + ".tmp"
+	file, err := sftpClient.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.ReadFrom(bytes.NewReader(binary)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := sftpClient.Chmod(tmpPath, 0755); err != nil {
+		return err
+	}
+	if got := remoteChecksum(client, tmpPath); got != checksum {
+		return fmt.Errorf("checksum mismatch: expected %s got %q", checksum, got)
+	}
+	return sftpClient.PosixRename(tmpPath, remotePath)
+}
+
+// remoteChecksum returns the sha256 of a remote file, or an empty string if
+// it cannot be computed
+func remoteChecksum(client *ssh.Client, remotePath string) string {
+	out, err := runOutput(client, fmt.Sprintf("sha256sum %s 2>/dev/null || shasum -a 256 %s", shellQuote(remotePath), shellQuote(remotePath)))
+	if err != nil {
+		return ""
+	}
+	if fields := strings.Fields(out); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// runOutput runs cmd in a new session and returns its output
+func runOutput(client *ssh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(cmd); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
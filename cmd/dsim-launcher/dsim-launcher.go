@@ -1,22 +1,42 @@
-// Este programa requiere 2 parámetros de entrada :
-//   - Nombre fichero json de Lefs
-//   - Número de ciclo final
+// dsim-launcher starts the simulation nodes and runs a partitioned Petri net
+// simulation over them.
 //
-// Ejemplo : censim  testdata/PrimerEjemplo.rdp.subred0.json  5
+//	dsim-launcher [flags] model           run a model with flags only
+//	dsim-launcher run [flags] spec.json   run described by a spec file
+//	dsim-launcher status [flags]          print the status of running nodes
+//	dsim-launcher plan [flags] spec.json|model
+//	                                      print the run plan without contacting nodes
+//	dsim-launcher shiviz [flags] [logs]   merge the text or JSON logs of a run into a ShiViz log
+//	dsim-launcher causality [flags] [logs]
+//	                                      check the vector clocks of the logs of a run
+//	dsim-launcher trace [flags] [traces]  merge the traces or JSON logs of a run into a Chrome trace
+//	dsim-launcher sweep [flags] sweep.json
+//	                                      run every point of a parameter grid and tabulate the statistics
+//
+// Every run has a run id, so nodes started with dsim-node -daemon and the
+// daemon backend can serve the runs of several launchers at the same time.
+// Launched nodes register their endpoint with the launcher, so nodes without
+// port in the node list listen on an ephemeral one. With -joinWindow, daemons
+// missing from the node list join the run by registering with the launcher.
+//
+// The launcher aggregates the transition statistics of the segments of a run.
+// With -replications it runs the model several times with distinct seeds and
+// estimates confidence intervals of the measures, with -batches it estimates
+// them from the batch means of a single run.
+//
+// Ejemplo : dsim-launcher -nodeFile data/simulation-nodes.json -nodeCmd dsim-node -period 10 data/3subredes
 package main
 
 import (
 	"encoding/gob"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"os/user"
-	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -27,13 +47,41 @@ import (
 	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
 	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
 	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
-	"golang.org/x/crypto/ssh"
 )
 
 type Node struct {
 	Name    string `json:"name"`
 	Address string `json:"address"`
 	Port    string `json:"port"`
+	// Backend used to start the node, ssh when empty
+	Backend string `json:"backend,omitempty"`
+	// Capacity weights the subnets placed on the node by the capacity
+	// placement strategy, 1 when empty
+	Capacity float64 `json:"capacity,omitempty"`
+	// MaxSubnets limits the subnets simulated by the node, no limit when empty
+	MaxSubnets int `json:"maxSubnets,omitempty"`
+	// Metrics is the address the launched node serves Prometheus metrics
+	// on, such as ":9100", none when empty
+	Metrics string `json:"metrics,omitempty"`
+	// SSHOptions override the launcher ssh flags for this node
+	SSHOptions
+}
+
+// transitionNode locates a segment hosted by the node
+func (n Node) transitionNode(segment string) dsim.TransitionNode {
+	return dsim.TransitionNode{
+		Name:    segment,
+		Node:    n.Name,
+		Address: n.Address,
+		Port:    n.Port,
+	}
+}
+
+func (n Node) backend() string {
+	if n.Backend == "" {
+		return SSHBackendName
+	}
+	return n.Backend
 }
 
 func init() {
@@ -43,304 +91,622 @@ func init() {
 	gob.Register(dsim.StartSimulationResponse{})
 	gob.Register(dsim.PrepareSimulationRequest{})
 	gob.Register(dsim.PrepareSimulationResponse{})
+	gob.Register(dsim.AbortSimulationRequest{})
+	gob.Register(dsim.AbortSimulationResponse{})
+	gob.Register(dsim.NodeFailureRequest{})
+	gob.Register(dsim.NodeFailureResponse{})
+	gob.Register(dsim.RegisterNodeRequest{})
+	gob.Register(dsim.RegisterNodeResponse{})
+	gob.Register(dsim.StatusRequest{})
+	gob.Register(dsim.StatusResponse{})
+	gob.Register(dsim.TerminationProbeRequest{})
+	gob.Register(dsim.TerminationProbeResponse{})
+	gob.Register(dsim.TerminateRequest{})
+	gob.Register(dsim.TerminateResponse{})
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 }
 
-var clog *clock.ClockLogger
-
-func main() {
-	// Create a channel to receive signals.
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+// exitStatuses maps the error codes reported by the nodes to launcher exit
+// statuses. Other failures exit with the abort reason code.
+var exitStatuses = map[communicator.ErrorCode]int{
+	communicator.ErrUnknown:          10,
+	communicator.ErrUnhandledMessage: 11,
+	dsim.ErrEngineAlreadyInitialized: 12,
+	dsim.ErrEngineNotInitialized:     13,
+	dsim.ErrEngineAlreadyRunning:     14,
+	dsim.ErrUnknownSegment:           15,
+	dsim.ErrNodeActive:               16,
+	dsim.ErrUnknownRun:               17,
+	dsim.ErrInvalidTopology:          18,
+}
 
-	home, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
+func exitStatus(reason dsim.AbortReason, err error) int {
+	var cerr *communicator.Error
+	if errors.As(err, &cerr) {
+		if status, ok := exitStatuses[cerr.Code]; ok {
+			return status
+		}
 	}
+	return reason.ExitCode()
+}
 
-	logsDir := fmt.Sprintf("%s/dsim/logs", home)
-	resultsDir := fmt.Sprintf("%s/dsim/results", home)
+// runError is the failure of a run, the nodes were aborted with its reason
+type runError struct {
+	Reason dsim.AbortReason
+	Cause  error
+}
 
-	os.MkdirAll(logsDir, os.ModePerm)
-	os.MkdirAll(resultsDir, os.ModePerm)
+func (e *runError) Error() string {
+	return e.Cause.Error()
+}
 
-	clog = clock.NewClockLog("dsl", clock.ClockLogConfig{
-		Priority:    clock.DEBUG,
-		FileOutput:  true,
-		LogFilename: fmt.Sprintf("%s/dsim-launcher.log", logsDir),
-	})
+func (e *runError) Unwrap() error {
+	return e.Cause
+}
+
+// runExitStatus is the exit status of the launcher for a failed run, 1 when
+// the run failed before any node was launched
+func runExitStatus(err error) int {
+	var rerr *runError
+	if errors.As(err, &rerr) {
+		return exitStatus(rerr.Reason, rerr.Cause)
+	}
+	return 1
+}
 
-	// cargamos un fichero de estructura Lef en formato json para centralizado
-	// os.Args[0] es el nombre del programa que no nos interesa
+// abortTimeout bounds the time spent notifying each node of an abort
+const abortTimeout = 2 * time.Second
+
+func main() {
+	var (
+		spec *RunSpec
+		err  error
+	)
+	switch {
+	case len(os.Args) > 1 && os.Args[1] == "status":
+		os.Exit(statusCommand(os.Args[2:]))
+	case len(os.Args) > 1 && os.Args[1] == "plan":
+		os.Exit(planCommand(os.Args[2:]))
+	case len(os.Args) > 1 && os.Args[1] == "shiviz":
+		os.Exit(shivizCommand(os.Args[2:]))
+	case len(os.Args) > 1 && os.Args[1] == "causality":
+		os.Exit(causalityCommand(os.Args[2:]))
+	case len(os.Args) > 1 && os.Args[1] == "trace":
+		os.Exit(traceCommand(os.Args[2:]))
+	case len(os.Args) > 1 && os.Args[1] == "sweep":
+		os.Exit(sweepCommand(os.Args[2:]))
+	case len(os.Args) > 1 && os.Args[1] == "run":
+		spec, err = parseRunSpec("run", os.Args[2:], true, nil)
+	default:
+		spec, err = parseRunSpec(os.Args[0], os.Args[1:], false, nil)
+	}
+	if err == nil {
+		err = spec.validateLaunch()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if spec.Replications > 1 {
+		os.Exit(runReplications(spec))
+	}
+	if _, err := runSimulation(spec); err != nil {
+		log.Print(err)
+		os.Exit(runExitStatus(err))
+	}
+}
 
-	var nodeFile string
-	flag.StringVar(&nodeFile, "nodeFile", "simulation-nodes.json", "The simulation nodes list")
+// newLauncherLog creates the log of the launcher in the logs directory of
+// the spec
+func newLauncherLog(spec *RunSpec) *clock.ClockLogger {
+	logsDir := spec.LogsDir
+	os.MkdirAll(logsDir, os.ModePerm)
 
-	var nodeCmd string
-	flag.StringVar(&nodeCmd, "nodeCmd", "", "The simulation node exec")
+	priority, _ := clock.ParseLogPriority(spec.LogLevel)
+	clogConfig := clock.ClockLogConfig{
+		Priority:      priority,
+		FileOutput:    true,
+		LogFilename:   fmt.Sprintf("%s/dsim-launcher.log", logsDir),
+		DisableClocks: spec.VectorClocks == VectorClocksOff,
+		HLCStamps:     spec.HLCStamps,
+	}
+	if spec.ShiViz {
+		clogConfig.ShiVizFilename = fmt.Sprintf("%s/dsim-launcher%s", logsDir, shivizFileExt)
+	}
+	if spec.JSONLogs {
+		clogConfig.JSONFilename = fmt.Sprintf("%s/dsim-launcher%s", logsDir, jsonLogFileExt)
+	}
+	return clock.NewClockLog("dsl", clogConfig)
+}
 
-	var period int
-	flag.IntVar(&period, "period", 10, "The simulation period")
+// runSimulation launches the nodes of the spec, runs the simulation and
+// returns its statistics once it has completed. On failure it aborts the
+// nodes and returns a *runError once their processes have ended, a failure
+// to collect the statistics is returned once the nodes have terminated. The run logs to its own launcher log.
+func runSimulation(spec *RunSpec) (*dsim.RunStatistics, error) {
+	// Create a channel to receive signals.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	done := make(chan struct{})
+	defer close(done)
 
-	// Enable command-line parsing
-	flag.Parse()
-	args := flag.Args()
+	logsDir := spec.LogsDir
+	resultsDir := spec.ResultsDir
 
-	nodeList := loadNodesFromFile(nodeFile)
+	os.MkdirAll(logsDir, os.ModePerm)
+	os.MkdirAll(resultsDir, os.ModePerm)
 
-	lefList, _ := loadLefs(args[0], nodeList)
+	clog := newLauncherLog(spec)
+	defer clog.Close()
 
-	// fmt.Println(nodeList)
-	// fmt.Println(lefList)
+	runId := spec.runId()
+	fmt.Printf("Run %s\n", runId)
+	clog.LogInfof("Starting run %s", runId)
 
-	if len(nodeList) < len(lefList) {
-		log.Fatal("Not enough nodes in node list")
+	nodeList, err := spec.nodeList()
+	if err != nil {
+		return nil, err
 	}
 
-	user, err := user.Current()
+	subnets, transitionLefMap, err := loadLefs(spec.Model)
 	if err != nil {
-		log.Fatalf(err.Error())
+		return nil, err
 	}
-	sshConfig := &ssh.ClientConfig{
-		User: user.Username,
-		Auth: []ssh.AuthMethod{
-			SSHAgent(),
-		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			log.Printf("hostkey: %q %v %v\n", hostname, remote, key)
-			return nil
-		},
+	if err := spec.setTransitionFields(subnets); err != nil {
+		return nil, err
+	}
+
+	lookaheads := make([]dsim.Clock, len(subnets))
+	for i, subnet := range subnets {
+		var err error
+		if lookaheads[i], err = spec.lookahead(subnet.Lefs); err != nil {
+			return nil, fmt.Errorf("subnet %s: %w", subnet.Name, err)
+		}
 	}
 
 	var wg sync.WaitGroup
-	var sshSessions []*ssh.Session
+	var (
+		mu              sync.Mutex
+		processes       []NodeProcess
+		simulationNodes []Node
+	)
+
+	// The first failure of the run is recorded and closes failed, later
+	// ones are ignored
+	var (
+		failOnce sync.Once
+		failure  *runError
+		failed   = make(chan struct{})
+	)
+
+	// shutdown aborts the simulation on every launched node, interrupts the
+	// node processes and kills those still alive after killTimeout
+	shutdown := func(reason dsim.AbortReason, cause error) {
+		failOnce.Do(func() {
+			failure = &runError{Reason: reason, Cause: cause}
+			close(failed)
+			mu.Lock()
+			defer mu.Unlock()
+			abortSimulation(clog, runId, simulationNodes, reason, cause.Error())
+			for _, process := range processes {
+				process.Interrupt()
+			}
+			exited := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(exited)
+			}()
+			select {
+			case <-exited:
+			case <-time.After(killTimeout):
+				for _, process := range processes {
+					process.Kill()
+				}
+			}
+		})
+	}
+	// fail shuts the run down and returns its first failure, which may have
+	// been reported concurrently
+	fail := func(reason dsim.AbortReason, cause error) error {
+		shutdown(reason, cause)
+		return failure
+	}
 
 	// Goroutine to catch shutdown signals
 	go func() {
-		sig := <-sigCh
-		fmt.Printf("Received signal: %v\n", sig)
-		for _, session := range sshSessions {
-			session.Signal(ssh.SIGINT)
+		select {
+		case sig := <-sigCh:
+			fmt.Printf("Received signal: %v\n", sig)
+			shutdown(dsim.AbortInterrupted, fmt.Errorf("launcher received signal %v", sig))
+		case <-done:
 		}
-		os.Exit(1)
 	}()
 
-	// Create a node map to detect duplicates and iterate in random way
-	nodeMap := make(map[string]Node, len(nodeList))
+	// Listen for registrations and failures reported by the simulation nodes
+	registry := newNodeRegistry()
+	listener, err := net.Listen("tcp", spec.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("launcher failed to start listener: %w", err)
+	}
+	defer listener.Close()
+	go communicator.HandleConnections(listener, func(conn net.Conn) {
+		defer conn.Close()
+		data, err := communicator.Receive(conn)
+		if err != nil {
+			clog.LogErrorf("error decoding message: %s", err)
+			return
+		}
+		clog.ObserveHLC(data)
+		switch mt := data.(type) {
+		case dsim.RegisterNodeRequest:
+			err := registry.register(mt, conn.RemoteAddr())
+			if err != nil {
+				clog.LogMergeErrorf(mt.Clock, "Registration of node %s rejected: %s", mt.Node, err)
+			} else {
+				clog.LogMergeInfof(mt.Clock, "Node %s registered on port %s", mt.Node, mt.Port)
+			}
+			communicator.Send(conn, dsim.RegisterNodeResponse{Response: launcherResponse(clog, err), Run: runId})
+		case dsim.NodeFailureRequest:
+			if mt.Run != runId {
+				clog.LogMergeErrorf(mt.Clock, "Failure of node %s reported for unknown run %s", mt.Pid, mt.Run)
+				communicator.Send(conn, dsim.NodeFailureResponse{Response: launcherResponse(clog, communicator.NewError(dsim.ErrUnknownRun, "launcher runs %s, not %s", runId, mt.Run))})
+				return
+			}
+			clog.LogMergeErrorf(mt.Clock, "Node %s failed on link %s -> %s: %s", mt.Pid, mt.Source, mt.Destination, mt.Error)
+			communicator.Send(conn, dsim.NodeFailureResponse{Response: launcherResponse(clog, nil)})
+			shutdown(dsim.AbortNodeFailure, fmt.Errorf("node %s failed on link %s -> %s: %w", mt.Pid, mt.Source, mt.Destination, mt.Error))
+		default:
+			clog.LogErrorf("%v message type received but not handled", mt)
+		}
+	})
+
+	// Daemons not in the node list join the run by registering during the
+	// join window, their number of cpus is their placement capacity
+	if spec.JoinWindow > 0 {
+		registry.openJoin(nodeList)
+		fmt.Printf("Accepting daemons registering at %s for %ds\n", listener.Addr(), spec.JoinWindow)
+		select {
+		case <-failed:
+			// Wait for the shutdown in progress to record its failure
+			failOnce.Do(func() {})
+			return nil, failure
+		case <-time.After(time.Duration(spec.JoinWindow) * time.Second):
+		}
+		for _, mt := range registry.closeJoin() {
+			fmt.Printf("Daemon %s joined at %s (host %s, %d cpus)\n", mt.Node,
+				net.JoinHostPort(mt.Address, mt.Port), mt.Capabilities.Hostname, mt.Capabilities.CPUs)
+			nodeList = append(nodeList, joinedNode(mt))
+		}
+	}
+
+	// Drop duplicated node services before placing the subnets, nodes on
+	// ephemeral ports cannot collide
+	seen := make(map[string]bool, len(nodeList))
+	uniqueNodes := nodeList[:0]
 	for _, node := range nodeList {
-		if _, ok := nodeMap[net.JoinHostPort(node.Address, node.Port)]; ok {
+		address := net.JoinHostPort(node.Address, node.Port)
+		if node.Port != "0" && seen[address] {
 			log.Printf("Warning: Duplicate simulation node service detected")
-		} else {
-			nodeMap[net.JoinHostPort(node.Address, node.Port)] = node
+			continue
 		}
+		seen[address] = true
+		uniqueNodes = append(uniqueNodes, node)
 	}
 
-	var simulationNodes []Node
+	placement, err := placeSubnets(subnets, subnetLinks(subnets, transitionLefMap), uniqueNodes, spec.Placement)
+	if err != nil {
+		return nil, fmt.Errorf("placement failed: %w", err)
+	}
+	placement.Print(os.Stdout)
+	topology, err := newTopology(placement)
+	if err != nil {
+		return nil, err
+	}
 
-	// Launch simulation nodes through ssh
-	for address, node := range nodeMap {
+	sshBackend := &SSHBackend{
+		Defaults: spec.SSH,
+		Stdin:    os.Stdin,
+	}
+	if spec.Deploy != "" {
+		sshBackend.Deployer = &Deployer{
+			Binaries: spec.Deploy,
+			CacheDir: spec.DeployDir,
+		}
+	}
+
+	daemonBackend := &DaemonBackend{
+		Run: runId,
+		Log: clog,
+	}
+	backends := map[string]LaunchBackend{
+		SSHBackendName:    sshBackend,
+		ExecBackendName:   &ExecBackend{},
+		DaemonBackendName: daemonBackend,
+	}
 
-		client := &SSHClient{
-			Config: sshConfig,
-			Host:   node.Address,
-			Port:   22,
+	// Launch simulation nodes with their backend
+	for _, node := range placement.NodeList() {
+		address := net.JoinHostPort(node.Address, node.Port)
+
+		backend, ok := backends[node.backend()]
+		if !ok {
+			return nil, fail(dsim.AbortLauncherError, fmt.Errorf("unknown launch backend %q for node %s", node.Backend, node.Name))
 		}
 
-		// Create a log for every session
+		// Create a log for every node process
 		var f io.Writer
-		f, _ = os.Create(fmt.Sprintf("%s/ssh-%s.log", logsDir, node.Name))
-
-		// Create ssh command
-		cmd := &SSHCommand{
-			Path: fmt.Sprintf("%s -listen %s -id %s -resultpath %s/%s.txt -logfile %s/%s.log", nodeCmd, address, node.Name, resultsDir, node.Name, logsDir, node.Name),
-			// Env:    []string{"LC_DIR=/"},
-			Stdin:  os.Stdin,
+		f, _ = os.Create(processLogFilename(logsDir, node.backend(), node.Name))
+
+		// Create node command, the node registers the port it listens on
+		cmd := &NodeCommand{
+			Path: spec.NodeCmd,
+			Args: []string{
+				"-listen", address,
+				"-id", node.Name,
+				"-register", registerAddress(listener.Addr(), node),
+				"-resultpath", fmt.Sprintf("%s/%s.txt", resultsDir, node.Name),
+				"-logfile", fmt.Sprintf("%s/%s.log", logsDir, node.Name),
+				"-loglevel", spec.NodeLogLevel,
+			},
 			Stdout: f,
 			Stderr: f,
 		}
+		if spec.ShiViz {
+			cmd.Args = append(cmd.Args, "-shivizfile", fmt.Sprintf("%s/%s%s", logsDir, node.Name, shivizFileExt))
+		}
+		if spec.VectorClocks == VectorClocksOff {
+			cmd.Args = append(cmd.Args, "-novectorclocks")
+		}
+		if spec.HLCStamps {
+			cmd.Args = append(cmd.Args, "-hlc")
+		}
+		if spec.Trace {
+			cmd.Args = append(cmd.Args, "-tracefile", fmt.Sprintf("%s/%s%s", logsDir, node.Name, traceFileExt))
+		}
+		if node.Metrics != "" {
+			cmd.Args = append(cmd.Args, "-metrics", node.Metrics)
+		}
+		if spec.JSONLogs {
+			cmd.Args = append(cmd.Args, "-jsonlogfile", fmt.Sprintf("%s/%s%s", logsDir, node.Name, jsonLogFileExt))
+		}
+		if node.backend() != DaemonBackendName {
+			registry.expect(node.Name)
+		}
 
-		fmt.Printf("Running command: %s\n", cmd.Path)
-
-		var (
-			session *ssh.Session
-			err     error
-		)
+		fmt.Printf("Running command with %s backend: %s\n", node.backend(), cmd)
 
-		if session, err = client.newSession(); err != nil {
-			log.Printf("Cannot open session to %v", client)
-			continue
+		process, err := backend.Launch(node, cmd)
+		if err != nil {
+			return nil, fail(dsim.AbortLauncherError, fmt.Errorf("cannot launch node %s: %w", node.Name, err))
 		}
 
+		mu.Lock()
+		processes = append(processes, process)
+		simulationNodes = append(simulationNodes, node)
+		mu.Unlock()
+
 		wg.Add(1)
-		go func() {
-			defer session.Close()
+		go func(node Node) {
 			defer wg.Done()
-			if err := client.RunCommand(session, cmd); err != nil {
+			if err := process.Wait(); err != nil {
 				fmt.Fprintf(os.Stderr, "command run error: %s\n", err)
-				sigCh <- syscall.SIGTERM
+				go shutdown(dsim.AbortNodeFailure, fmt.Errorf("node %s exited: %w", node.Name, err))
 			}
-		}()
+		}(node)
+	}
 
-		if checkSimulationNode(node) == nil {
-			log.Printf("Node check %v", node)
-			sshSessions = append(sshSessions, session)
-			simulationNodes = append(simulationNodes, node)
-		} else {
-			session.Close()
+	// Launched nodes must register before the simulation is prepared, their
+	// registration gives the port they listen on. Daemons are already
+	// serving on the port of the node list.
+	registerTimeout := time.Duration(spec.RegisterTimeout) * time.Second
+	for i, node := range simulationNodes {
+		if node.backend() == DaemonBackendName {
+			if _, err := queryNodeStatus(clog, node, runId, probeTimeout); err != nil {
+				return nil, fail(dsim.AbortLauncherError, fmt.Errorf("daemon %s is not reachable: %w", node.Name, err))
+			}
 			continue
 		}
-
-		if len(simulationNodes) == len(lefList) {
-			break
+		registration, err := registry.wait(node.Name, registerTimeout)
+		if err != nil {
+			return nil, fail(dsim.AbortLauncherError, err)
 		}
+		fmt.Printf("Node %s registered at %s (host %s, %d cpus)\n", node.Name,
+			net.JoinHostPort(registration.Address, registration.Port),
+			registration.Capabilities.Hostname, registration.Capabilities.CPUs)
+		if metrics := registration.Capabilities.MetricsAddress; metrics != "" {
+			fmt.Printf("Node %s serves metrics at http://%s/metrics\n", node.Name, dsim.ResolveAddress(metrics, &net.TCPAddr{IP: net.ParseIP(registration.Address)}))
+		}
+		node.Address, node.Port = registration.Address, registration.Port
+		mu.Lock()
+		simulationNodes[i] = node
+		mu.Unlock()
+		placement.moveNode(node)
+	}
+	for i, subnet := range placement.Subnets {
+		topology.Segments[subnet.Name] = placement.Nodes[i].transitionNode(subnet.Name)
 	}
 
-	// Got matchin nodes
-	if len(simulationNodes) == len(lefList) {
-		// Map node address to transition
-		transitionNodes := createTransitionNodeMap(lefList, simulationNodes)
-		nodesToFrom := make(map[string][]string)
-		nodesFromTo := make(map[string][]dsim.TransitionNode)
-		for _, l := range lefList {
-			for _, n := range l.Network {
-				if n.External {
-					for _, t := range n.Propagate {
-						propagateNode := transitionNodes[(1+t.TransitionId)*-1]
-						localNode := transitionNodes[n.Id]
-						nodesToFrom[propagateNode.Name] = append(nodesToFrom[propagateNode.Name], localNode.Name)
-						nodesFromTo[localNode.Name] = append(nodesFromTo[localNode.Name], propagateNode)
-					}
-				}
-			}
+	// Compact clocks are indexed by the launcher and node pids
+	var clockPids []string
+	if spec.VectorClocks == VectorClocksCompact {
+		clockPids = append(clockPids, clog.GetPid())
+		for _, node := range simulationNodes {
+			clockPids = append(clockPids, node.Name)
 		}
+	}
 
-		for i, node := range simulationNodes {
-			sendNetworkToNode(node, lefList[i], transitionNodes, nodesToFrom[node.Name], nodesFromTo[node.Name])
+	for i, node := range placement.Nodes {
+		segment := subnets[i].Name
+		if err := sendNetworkToNode(clog, runId, node, listener.Addr().String(), segment, subnets[i].Lefs, lookaheads[i], topology, clockPids, spec); err != nil {
+			clog.LogErrorf("Prepare simulation failed: %s", err)
+			return nil, fail(dsim.AbortLauncherError, err)
 		}
-		launchSimulation(simulationNodes, period)
+	}
+	if err := launchSimulation(clog, runId, simulationNodes, dsim.Clock(spec.End)); err != nil {
+		clog.LogErrorf("Start simulation failed: %s", err)
+		return nil, fail(dsim.AbortLauncherError, err)
+	}
 
-		wg.Wait()
+	if err := detectTermination(clog, runId, simulationNodes, failed); err != nil {
+		clog.LogErrorf("Termination detection failed: %s", err)
+		return nil, fail(dsim.AbortNodeFailure, err)
+	}
+	// Nodes are terminated even when their statistics cannot be collected
+	stats, statsErr := collectStatistics(clog, runId, simulationNodes)
+	if statsErr != nil {
+		clog.LogErrorf("Collect statistics failed: %s", statsErr)
 	} else {
-		sigCh <- syscall.SIGINT
-		log.Print(fmt.Errorf("not enough nodes"))
+		reportStatistics(clog, spec, stats)
 	}
-}
-
-func loadLefs(networkFilesLocation string, nodeList []Node) ([]dsim.Lefs, map[dsim.TransitionId]int) {
-
-	matches, err := filepath.Glob(networkFilesLocation + ".subred*.json")
-
-	if err != nil {
-		log.Fatal(err)
+	if err := terminateSimulation(clog, runId, simulationNodes); err != nil {
+		clog.LogErrorf("Terminate simulation failed: %s", err)
+		return nil, fail(dsim.AbortLauncherError, err)
 	}
-
-	if len(matches) == 0 {
-		log.Fatalf("No subnetworks found")
+	clog.LogInfof("Simulation completed")
+	daemonBackend.Release()
+
+	wg.Wait()
+	// Failures reported from now on no longer concern the run, one being
+	// shut down is waited for
+	failOnce.Do(func() {})
+	if failure != nil {
+		return nil, failure
 	}
-
-	// Map with networks
-	networkList := make([]dsim.Lefs, len(matches))
-
-	// Map with global ids and
-	transitionLefMap := make(map[dsim.TransitionId]int)
-
-	for i, networkFile := range matches {
-		lef, _ := dsim.Load(networkFile)
-		networkList[i] = lef
-		for _, v := range lef.Network {
-			transitionLefMap[v.Id] = i
-		}
+	if statsErr != nil {
+		return nil, fmt.Errorf("collect statistics of run %s: %w", runId, statsErr)
 	}
-	return networkList, transitionLefMap
+	return &stats, nil
 }
 
-func loadNodesFromFile(nodeFile string) []Node {
-	var (
-		nodeList []Node
-		file     []byte
-		err      error
-	)
-	if file, err = os.ReadFile(nodeFile); err != nil {
-		log.Fatalf(err.Error())
+func loadNodesFromFile(nodeFile string) ([]Node, error) {
+	var nodeList []Node
+	file, err := os.ReadFile(nodeFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(file, &nodeList); err != nil {
+		return nil, fmt.Errorf("invalid node file %s: %w", nodeFile, err)
 	}
-	_ = json.Unmarshal([]byte(file), &nodeList)
-	return nodeList
+	return nodeList, nil
 }
 
-func launchSimulation(simulationNodes []Node, period int) {
+func launchSimulation(clog *clock.ClockLogger, run dsim.RunId, simulationNodes []Node, end dsim.Clock) error {
 
 	for _, v := range simulationNodes {
 
 		address := net.JoinHostPort(v.Address, v.Port)
 		cc := clog.LogInfof("Send start simulation request to %s", address)
-		response, _ := communicator.SendReceiveTCP(address, dsim.StartSimulationRequest{
-			Request: communicator.RequestWithClock(clog.GetPid(), cc),
-			End:     dsim.Clock(period),
+		response, err := communicator.SendReceiveTCP(address, dsim.StartSimulationRequest{
+			Request: communicator.Request{ClockPayload: clog.Payload(cc)},
+			Run:     run,
+			End:     end,
 		})
+		if err != nil {
+			return fmt.Errorf("start simulation on %v: %w", v.Name, err)
+		}
+		clog.ObserveHLC(response)
 
 		switch mt := response.(type) {
 		case dsim.StartSimulationResponse:
 			if mt.Error == nil {
 				log.Printf("Received success from %v", v.Name)
 			} else {
-				log.Fatalf("Received unsucessful response from %v: %s", v.Name, mt.Error)
+				return fmt.Errorf("received unsucessful response from %v: %w", v.Name, mt.Error)
 			}
+		case communicator.Response:
+			if mt.Error != nil {
+				return fmt.Errorf("received error response from %v: %w", v.Name, mt.Error)
+			}
+			return fmt.Errorf("received unexpected response from %v: %+v", v.Name, mt)
 		default:
-			log.Fatalf("Received  unknown response from controller")
+			return fmt.Errorf("received unknown response from %v: %+v", v.Name, mt)
 		}
 	}
+	return nil
 }
 
-func sendNetworkToNode(node Node, lef dsim.Lefs, transitionNodes map[dsim.TransitionId]dsim.TransitionNode, waitingOnSegments []string, notificationSegments []dsim.TransitionNode) {
+func sendNetworkToNode(clog *clock.ClockLogger, run dsim.RunId, node Node, launcherAddress string, segment string, lef dsim.Lefs, lookahead dsim.Clock, topology *dsim.Topology, clockPids []string, spec *RunSpec) error {
 	address := net.JoinHostPort(node.Address, node.Port)
-	cc := clog.LogInfof("Send prepare simulation request to %s", address)
-	response, _ := communicator.SendReceiveTCP(address,
+	cc := clog.LogInfof("Send prepare simulation request for %s to %s", segment, address)
+	response, err := communicator.SendReceiveTCP(address,
 		dsim.PrepareSimulationRequest{
-			Request:              communicator.RequestWithClock(clog.GetPid(), cc),
-			Lefs:                 lef,
-			TransitionNodes:      transitionNodes,
-			WaitingOnSegments:    waitingOnSegments,
-			NotificationSegments: notificationSegments,
+			Request:         communicator.Request{ClockPayload: clog.Payload(cc)},
+			Run:             run,
+			LauncherAddress: launcherAddress,
+			Segment:         segment,
+			Lefs:            lef,
+			Lookahead:       lookahead,
+			Topology:        *topology,
+			ClockPids:       clockPids,
+			Durations:       dsim.DurationDistribution(spec.Durations),
+			Seed:            spec.Seed,
+			Statistics: dsim.StatisticsConfig{
+				WarmUp:  dsim.Clock(spec.WarmUp),
+				Batches: spec.Batches,
+			},
 		})
+	if err != nil {
+		return fmt.Errorf("prepare simulation of %s on %v: %w", segment, node.Name, err)
+	}
+	clog.ObserveHLC(response)
 
 	switch mt := response.(type) {
 	case dsim.PrepareSimulationResponse:
 		if mt.Error == nil {
 			log.Printf("Received success from %v", node.Name)
 		} else {
-			log.Fatalf("Received unsucessful response from %v: %s", node.Name, mt.Error)
+			return fmt.Errorf("received unsucessful response from %v: %w", node.Name, mt.Error)
+		}
+	case communicator.Response:
+		if mt.Error != nil {
+			return fmt.Errorf("received error response from %v: %w", node.Name, mt.Error)
 		}
+		return fmt.Errorf("received unexpected response from %v: %+v", node.Name, mt)
 	default:
-		log.Fatalf("Received  unknown response from controller :%+v", mt)
+		return fmt.Errorf("received unknown response from %v: %+v", node.Name, mt)
 	}
+	return nil
 }
 
-func createTransitionNodeMap(lefs []dsim.Lefs, simulationNodes []Node) map[dsim.TransitionId]dsim.TransitionNode {
-	transitionNodeMap := make(map[dsim.TransitionId]dsim.TransitionNode)
-	for i, l := range lefs {
-		for _, n := range l.Network {
-			transitionNodeMap[n.Id] = dsim.TransitionNode(simulationNodes[i])
-		}
+// abortSimulation sends an abort request to every node, ignoring nodes which
+// cannot be reached as they may have already exited.
+func abortSimulation(clog *clock.ClockLogger, run dsim.RunId, simulationNodes []Node, reason dsim.AbortReason, message string) {
+	var wg sync.WaitGroup
+	for _, v := range simulationNodes {
+		wg.Add(1)
+		go func(v Node) {
+			defer wg.Done()
+			address := net.JoinHostPort(v.Address, v.Port)
+			cc := clog.LogErrorf("Send abort simulation request to %s: %s", address, reason)
+			response, err := communicator.SendReceiveTCPTimeout(address, dsim.AbortSimulationRequest{
+				Request: communicator.Request{ClockPayload: clog.Payload(cc)},
+				Run:     run,
+				Reason:  reason,
+				Message: message,
+			}, abortTimeout)
+			if err != nil {
+				log.Printf("Abort request to %v failed: %s", v.Name, err)
+				return
+			}
+			clog.ObserveHLC(response)
+		}(v)
 	}
-	return transitionNodeMap
+	wg.Wait()
 }
 
-func checkSimulationNode(node Node) error {
-	timeToSleep := time.Duration(500) * time.Millisecond
-	timeout := 2 * time.Second
-	i := 0
-	for {
-		conn, err := net.DialTimeout("tcp", net.JoinHostPort(node.Address, node.Port), timeout)
-		if conn != nil {
-			conn.Close()
-			return nil
-		}
-		if i < 5 {
-			time.Sleep(timeToSleep)
-			timeToSleep *= 2
-			i++
-		} else {
-			return err
-		}
+// launcherResponse returns a response stamped with the hybrid logical clock
+// of the launcher
+func launcherResponse(clog *clock.ClockLogger, err *communicator.Error) communicator.Response {
+	return communicator.Response{ClockPayload: clog.ResponsePayload(), Error: err}
+}
+
+// newTopology builds the link topology of the placed subnets
+func newTopology(placement *Placement) (*dsim.Topology, error) {
+	nodes := make([]dsim.TransitionNode, len(placement.Subnets))
+	lefs := make([]dsim.Lefs, len(placement.Subnets))
+	for i, subnet := range placement.Subnets {
+		nodes[i] = placement.Nodes[i].transitionNode(subnet.Name)
+		lefs[i] = subnet.Lefs
 	}
+	return dsim.NewTopology(nodes, lefs)
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// Launch backends selectable per node with the backend field of the node file
+const (
+	SSHBackendName  = "ssh"
+	ExecBackendName = "exec"
+	// DaemonBackendName uses nodes already running as dsim-node -daemon
+	DaemonBackendName = "daemon"
+)
+
+// processLogFilename names the log of the output of a node process started
+// by a backend
+func processLogFilename(logsDir, backend, node string) string {
+	return fmt.Sprintf("%s/%s-%s.log", logsDir, backend, node)
+}
+
+// isProcessLog tells whether a log file holds the output of a node process
+// instead of a node log
+func isProcessLog(name string) bool {
+	for _, backend := range []string{SSHBackendName, ExecBackendName, DaemonBackendName} {
+		if strings.HasPrefix(name, backend+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// killTimeout is the time given to interrupted nodes before killing them
+const killTimeout = 5 * time.Second
+
+// NodeCommand is the dsim-node invocation run by a launch backend
+type NodeCommand struct {
+	Path   string
+	Args   []string
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (cmd *NodeCommand) String() string {
+	return strings.Join(append([]string{cmd.Path}, cmd.Args...), " ")
+}
+
+// NodeProcess is a simulation node started by a launch backend
+type NodeProcess interface {
+	// Wait blocks until the node exits and returns its exit error
+	Wait() error
+	// Interrupt asks the node to abort the simulation and exit
+	Interrupt() error
+	// Kill stops the node at once and releases the backend resources
+	Kill() error
+}
+
+// LaunchBackend starts simulation nodes
+type LaunchBackend interface {
+	Launch(node Node, cmd *NodeCommand) (NodeProcess, error)
+}
+
+// ExecBackend starts simulation nodes as child processes of the launcher
+type ExecBackend struct{}
+
+type execProcess struct {
+	cmd *exec.Cmd
+}
+
+func (b *ExecBackend) Launch(node Node, cmd *NodeCommand) (NodeProcess, error) {
+	c := exec.Command(cmd.Path, cmd.Args...)
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	if err := c.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+	}
+	return &execProcess{cmd: c}, nil
+}
+
+func (p *execProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+func (p *execProcess) Interrupt() error {
+	return p.cmd.Process.Signal(os.Interrupt)
+}
+
+func (p *execProcess) Kill() error {
+	if err := p.cmd.Process.Kill(); err != nil && err != os.ErrProcessDone {
+		return err
+	}
+	return nil
+}
+
+// daemonPollInterval is the wait between two status requests to a daemon
+const daemonPollInterval = time.Second
+
+// DaemonBackend takes part in a run with nodes started beforehand with
+// dsim-node -daemon, shared with other runs. Nothing is started: the node
+// process of a daemon lasts as long as the run does on it.
+type DaemonBackend struct {
+	Run dsim.RunId
+	// Log is the launcher log of the run, the daemons are polled with it
+	Log         *clock.ClockLogger
+	released    chan struct{}
+	releaseOnce sync.Once
+}
+
+type daemonProcess struct {
+	node          Node
+	run           dsim.RunId
+	log           *clock.ClockLogger
+	released      <-chan struct{}
+	interrupted   chan struct{}
+	interruptOnce sync.Once
+	stopped       chan struct{}
+	stopOnce      sync.Once
+}
+
+func (b *DaemonBackend) Launch(node Node, cmd *NodeCommand) (NodeProcess, error) {
+	return &daemonProcess{
+		node:        node,
+		run:         b.Run,
+		log:         b.Log,
+		released:    b.releaseChannel(),
+		interrupted: make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}, nil
+}
+
+func (b *DaemonBackend) releaseChannel() chan struct{} {
+	b.releaseOnce.Do(func() {
+		b.released = make(chan struct{})
+	})
+	return b.released
+}
+
+// Release tells the daemon processes that the run has been terminated, so a
+// run too short to be seen by their polling still ends their Wait
+func (b *DaemonBackend) Release() {
+	close(b.releaseChannel())
+}
+
+// Wait polls the daemon until the run has been registered and removed again,
+// failing if the daemon cannot be reached. A run registered and removed
+// between two polls is never seen, so once the launcher releases or
+// interrupts the process Wait also ends when the daemon does not hold the
+// run.
+func (p *daemonProcess) Wait() error {
+	registered := false
+	released, interrupted := p.released, p.interrupted
+	for {
+		status, err := queryNodeStatus(p.log, p.node, p.run, probeTimeout)
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(status.Runs) > 0:
+			registered = true
+		case registered, released == nil:
+			return nil
+		}
+		select {
+		case <-p.stopped:
+			return nil
+		case <-released:
+			released, interrupted = nil, nil
+		case <-interrupted:
+			released, interrupted = nil, nil
+		case <-time.After(daemonPollInterval):
+		}
+	}
+}
+
+// Interrupt does not stop the daemon, the run is aborted on it by the
+// launcher and Wait returns once the daemon forgets it
+func (p *daemonProcess) Interrupt() error {
+	p.interruptOnce.Do(func() {
+		close(p.interrupted)
+	})
+	return nil
+}
+
+func (p *daemonProcess) Kill() error {
+	p.stopOnce.Do(func() {
+		close(p.stopped)
+	})
+	return nil
+}
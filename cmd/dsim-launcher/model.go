@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// subnetFilePattern extracts the subnet number from <model>.subred<N>.json
+var subnetFilePattern = regexp.MustCompile(`\.subred(\d+)\.json$`)
+
+// Subnet is a partition of the model simulated by one simulation engine
+type Subnet struct {
+	// Index is N in the file name <model>.subred<N>.json
+	Index int
+	Name  string
+	File  string
+	Lefs  dsim.Lefs
+}
+
+// loadLefs loads the subnets of a model sorted by index, and maps every
+// global transition id to the position of its subnet in the result.
+func loadLefs(networkFilesLocation string) ([]Subnet, map[dsim.TransitionId]int, error) {
+
+	matches, err := filepath.Glob(networkFilesLocation + ".subred*.json")
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(matches) == 0 {
+		return nil, nil, fmt.Errorf("no subnetworks found for %s", networkFilesLocation)
+	}
+
+	subnets := make([]Subnet, 0, len(matches))
+	for _, networkFile := range matches {
+		m := subnetFilePattern.FindStringSubmatch(networkFile)
+		if m == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(m[1])
+		lef, err := dsim.Load(networkFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load %s: %w", networkFile, err)
+		}
+		subnets = append(subnets, Subnet{
+			Index: index,
+			Name:  fmt.Sprintf("subred%d", index),
+			File:  networkFile,
+			Lefs:  lef,
+		})
+	}
+	sort.Slice(subnets, func(i, j int) bool { return subnets[i].Index < subnets[j].Index })
+
+	// Map with global ids and subnet positions
+	transitionLefMap := make(map[dsim.TransitionId]int)
+	for i, subnet := range subnets {
+		for _, v := range subnet.Lefs.Network {
+			transitionLefMap[v.Id] = i
+		}
+	}
+	return subnets, transitionLefMap, nil
+}
+
+// subnetLinks counts the external propagation arcs between subnets, keyed by
+// the positions of the source and destination subnets. Arcs to transitions
+// missing from every subnet are ignored.
+func subnetLinks(subnets []Subnet, transitionLefMap map[dsim.TransitionId]int) map[int]map[int]int {
+	links := make(map[int]map[int]int)
+	for i, subnet := range subnets {
+		for _, t := range subnet.Lefs.Network {
+			if !t.External {
+				continue
+			}
+			for _, p := range t.Propagate {
+				if p.TransitionId >= 0 {
+					continue
+				}
+				j, ok := transitionLefMap[(1+p.TransitionId)*-1]
+				if !ok || j == i {
+					continue
+				}
+				if links[i] == nil {
+					links[i] = make(map[int]int)
+				}
+				links[i][j]++
+			}
+		}
+	}
+	return links
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"text/tabwriter"
+)
+
+// Placement strategies for the subnets not placed explicitly
+const (
+	// PlacementRoundRobin assigns subnets to nodes in node list order,
+	// skipping the nodes with more subnets assigned explicitly
+	PlacementRoundRobin = "roundRobin"
+	// PlacementCapacity balances the transitions of every node against its
+	// capacity, placing larger subnets first
+	PlacementCapacity = "capacity"
+	// PlacementMinCrossHost keeps linked subnets on nodes of the same host
+	PlacementMinCrossHost = "minCrossHost"
+)
+
+// PlacementSpec selects the node simulating every subnet
+type PlacementSpec struct {
+	Strategy string `json:"strategy"`
+	// Assignments maps subnet names (subred<N>) to node names, these subnets
+	// are not placed by the strategy
+	Assignments map[string]string `json:"assignments,omitempty"`
+}
+
+// Placement is the node assigned to every subnet of a model
+type Placement struct {
+	Strategy string
+	Subnets  []Subnet
+	// Nodes holds the node of every subnet, in the order of Subnets
+	Nodes []Node
+	links map[int]map[int]int
+}
+
+// placeSubnets assigns a node of nodeList to every subnet, first those in the
+// spec assignments and then the rest with the spec strategy
+func placeSubnets(subnets []Subnet, links map[int]map[int]int, nodeList []Node, spec PlacementSpec) (*Placement, error) {
+	p := &Placement{
+		Strategy: spec.Strategy,
+		Subnets:  subnets,
+		Nodes:    make([]Node, len(subnets)),
+		links:    links,
+	}
+
+	nodeIndex := make(map[string]int, len(nodeList))
+	for i, node := range nodeList {
+		if node.Capacity < 0 {
+			return nil, fmt.Errorf("node %s has negative capacity %v", node.Name, node.Capacity)
+		}
+		nodeIndex[node.Name] = i
+	}
+	load := make([]int, len(nodeList))
+	placed := make([]bool, len(subnets))
+
+	subnetIndex := make(map[string]int, len(subnets))
+	for i, subnet := range subnets {
+		subnetIndex[subnet.Name] = i
+	}
+	for subnetName, nodeName := range spec.Assignments {
+		s, ok := subnetIndex[subnetName]
+		if !ok {
+			return nil, fmt.Errorf("placement of unknown subnet %s", subnetName)
+		}
+		n, ok := nodeIndex[nodeName]
+		if !ok {
+			return nil, fmt.Errorf("subnet %s placed on unknown node %s", subnetName, nodeName)
+		}
+		if load[n] == nodeList[n].maxSubnets(len(subnets)) {
+			return nil, fmt.Errorf("node %s cannot simulate more than %d subnets", nodeName, nodeList[n].MaxSubnets)
+		}
+		p.Nodes[s] = nodeList[n]
+		placed[s] = true
+		load[n]++
+	}
+
+	pending := make([]int, 0, len(subnets))
+	for i := range subnets {
+		if !placed[i] {
+			pending = append(pending, i)
+		}
+	}
+	// full reports whether node n cannot take more subnets
+	full := func(n int) bool {
+		return load[n] == nodeList[n].maxSubnets(len(subnets))
+	}
+	free := 0
+	for n, l := range load {
+		free += nodeList[n].maxSubnets(len(subnets)) - l
+	}
+	if free < len(pending) {
+		return nil, fmt.Errorf("not enough nodes: %d subnets to place on %d free node slots", len(pending), free)
+	}
+
+	var place func(s int) int
+	switch spec.Strategy {
+	case PlacementRoundRobin:
+		// Take turns among the least loaded nodes, so explicit assignments
+		// count as turns already taken
+		next := 0
+		place = func(s int) int {
+			best := -1
+			for k := range nodeList {
+				n := (next + k) % len(nodeList)
+				if !full(n) && (best < 0 || load[n] < load[best]) {
+					best = n
+				}
+			}
+			next = (best + 1) % len(nodeList)
+			return best
+		}
+	case PlacementCapacity:
+		sort.SliceStable(pending, func(i, j int) bool {
+			return subnets[pending[i]].weight() > subnets[pending[j]].weight()
+		})
+		transitions := make([]int, len(nodeList))
+		for s, node := range p.Nodes {
+			if placed[s] {
+				transitions[nodeIndex[node.Name]] += subnets[s].weight()
+			}
+		}
+		place = func(s int) int {
+			best, bestRatio := -1, 0.0
+			for n, node := range nodeList {
+				if full(n) {
+					continue
+				}
+				ratio := float64(transitions[n]+subnets[s].weight()) / node.capacity()
+				if best < 0 || ratio < bestRatio {
+					best, bestRatio = n, ratio
+				}
+			}
+			transitions[best] += subnets[s].weight()
+			return best
+		}
+	case PlacementMinCrossHost:
+		// Place the most linked subnets first so their neighbours follow them
+		sort.SliceStable(pending, func(i, j int) bool {
+			return p.degree(pending[i]) > p.degree(pending[j])
+		})
+		place = func(s int) int {
+			hostLinks := make(map[string]int)
+			nodeLinks := make(map[string]int)
+			for t := range subnets {
+				if placed[t] {
+					hostLinks[p.Nodes[t].Address] += p.linksBetween(s, t)
+					nodeLinks[p.Nodes[t].Name] += p.linksBetween(s, t)
+				}
+			}
+			hostFree := make(map[string]int)
+			for n, node := range nodeList {
+				hostFree[node.Address] += node.maxSubnets(len(subnets)) - load[n]
+			}
+			// Prefer the host and then the node with more links to the
+			// subnet, subnets on the same node exchange messages in-process
+			better := func(n, best int) bool {
+				a, b := nodeList[n], nodeList[best]
+				if hostLinks[a.Address] != hostLinks[b.Address] {
+					return hostLinks[a.Address] > hostLinks[b.Address]
+				}
+				if nodeLinks[a.Name] != nodeLinks[b.Name] {
+					return nodeLinks[a.Name] > nodeLinks[b.Name]
+				}
+				if hostFree[a.Address] != hostFree[b.Address] {
+					return hostFree[a.Address] > hostFree[b.Address]
+				}
+				return load[n] < load[best]
+			}
+			best := -1
+			for n := range nodeList {
+				if !full(n) && (best < 0 || better(n, best)) {
+					best = n
+				}
+			}
+			return best
+		}
+	default:
+		return nil, fmt.Errorf("unknown placement strategy %q", spec.Strategy)
+	}
+
+	for _, s := range pending {
+		n := place(s)
+		p.Nodes[s] = nodeList[n]
+		placed[s] = true
+		load[n]++
+	}
+	return p, nil
+}
+
+// weight is the placement cost of a subnet, its number of transitions
+func (s Subnet) weight() int {
+	if len(s.Lefs.Network) == 0 {
+		return 1
+	}
+	return len(s.Lefs.Network)
+}
+
+// maxSubnets is the number of subnets the node can simulate out of subnets,
+// all of them when the node sets no limit
+func (n Node) maxSubnets(subnets int) int {
+	if n.MaxSubnets == 0 || n.MaxSubnets > subnets {
+		return subnets
+	}
+	return n.MaxSubnets
+}
+
+func (n Node) capacity() float64 {
+	if n.Capacity == 0 {
+		return 1
+	}
+	return n.Capacity
+}
+
+// linksBetween counts the arcs between two subnets in both directions
+func (p *Placement) linksBetween(s, t int) int {
+	return p.links[s][t] + p.links[t][s]
+}
+
+func (p *Placement) degree(s int) int {
+	degree := 0
+	for t := range p.Subnets {
+		degree += p.linksBetween(s, t)
+	}
+	return degree
+}
+
+// LocalLinks counts the arcs between subnets placed on the same node, which
+// are delivered in-process
+func (p *Placement) LocalLinks() int {
+	local := 0
+	for s, to := range p.links {
+		for t, arcs := range to {
+			if p.Nodes[s].Name == p.Nodes[t].Name {
+				local += arcs
+			}
+		}
+	}
+	return local
+}
+
+// CrossHostLinks counts the arcs between subnets placed on different hosts
+// and the total number of arcs between subnets
+func (p *Placement) CrossHostLinks() (cross, total int) {
+	for s, to := range p.links {
+		for t, arcs := range to {
+			total += arcs
+			if p.Nodes[s].Address != p.Nodes[t].Address {
+				cross += arcs
+			}
+		}
+	}
+	return cross, total
+}
+
+// moveNode updates the endpoint of the subnets placed on the node
+func (p *Placement) moveNode(node Node) {
+	for i := range p.Nodes {
+		if p.Nodes[i].Name == node.Name {
+			p.Nodes[i] = node
+		}
+	}
+}
+
+// NodeList returns the placed nodes without repetitions, in subnet order
+func (p *Placement) NodeList() []Node {
+	seen := make(map[string]bool)
+	nodes := make([]Node, 0, len(p.Nodes))
+	for _, node := range p.Nodes {
+		if !seen[node.Name] {
+			seen[node.Name] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Print writes the placement plan as a table
+func (p *Placement) Print(w io.Writer) {
+	fmt.Fprintf(w, "Placement plan (%s):\n", p.Strategy)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SUBNET\tTRANSITIONS\tNODE\tADDRESS")
+	for i, subnet := range p.Subnets {
+		node := p.Nodes[i]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", subnet.Name, len(subnet.Lefs.Network), node.Name, net.JoinHostPort(node.Address, node.Port))
+	}
+	tw.Flush()
+	cross, total := p.CrossHostLinks()
+	fmt.Fprintf(w, "Cross-host links: %d of %d, in-process links: %d\n", cross, total, p.LocalLinks())
+}
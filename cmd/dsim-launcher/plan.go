@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// Plan describes what a run would do without contacting any node
+type Plan struct {
+	Model             string       `json:"model"`
+	PlacementStrategy string       `json:"placementStrategy"`
+	Subnets           []PlanSubnet `json:"subnets"`
+	Arcs              []PlanArc    `json:"arcs"`
+	UnresolvedArcs    []PlanArc    `json:"unresolvedArcs,omitempty"`
+	TopologyErrors    []string     `json:"topologyErrors,omitempty"`
+	Links             []PlanLink   `json:"links"`
+	ZeroLookahead     [][]string   `json:"zeroLookaheadCycles"`
+	CrossHostLinks    int          `json:"crossHostLinks"`
+	InProcessLinks    int          `json:"inProcessLinks"`
+	PlacementError    string       `json:"placementError,omitempty"`
+	placement         *Placement
+	linkIndex         map[[2]string]int
+}
+
+// PlanSubnet is a subnet of the model and the node it is placed on
+type PlanSubnet struct {
+	Name              string     `json:"name"`
+	File              string     `json:"file"`
+	Transitions       int        `json:"transitions"`
+	OutputTransitions int        `json:"outputTransitions"`
+	Lookahead         dsim.Clock `json:"lookahead"`
+	LookaheadError    string     `json:"lookaheadError,omitempty"`
+	Node              string     `json:"node,omitempty"`
+	Address           string     `json:"address,omitempty"`
+}
+
+// PlanArc is an external propagation from a transition to a transition of
+// another subnet
+type PlanArc struct {
+	Source                string            `json:"source"`
+	SourceTransition      dsim.TransitionId `json:"sourceTransition"`
+	Destination           string            `json:"destination,omitempty"`
+	DestinationTransition dsim.TransitionId `json:"destinationTransition"`
+	Duration              dsim.Clock        `json:"duration"`
+}
+
+// PlanLink aggregates the arcs from one subnet to another. Lookahead is the
+// one announced by the source, MinDuration the earliest an event on the
+// link can be scheduled after the source clock.
+type PlanLink struct {
+	Source      string     `json:"source"`
+	Destination string     `json:"destination"`
+	Arcs        int        `json:"arcs"`
+	Lookahead   dsim.Clock `json:"lookahead"`
+	MinDuration dsim.Clock `json:"minDuration"`
+	CrossHost   bool       `json:"crossHost"`
+	InProcess   bool       `json:"inProcess"`
+}
+
+// planCommand prints the plan of a run, given a spec file or a model
+func planCommand(args []string) int {
+	var format string
+	bindPlanFlags := func(fs *flag.FlagSet) {
+		fs.StringVar(&format, "format", "text", "The plan output format (text or json)")
+	}
+	spec, err := parseRunSpec("plan", args, isSpecFile(args, bindPlanFlags), bindPlanFlags)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	subnets, transitionLefMap, err := loadLefs(spec.Model)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	nodeList, err := spec.nodeList()
+	if err != nil {
+		log.Print(err)
+	}
+	plan := newPlan(spec, subnets, transitionLefMap, nodeList)
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			log.Print(err)
+			return 1
+		}
+	case "text":
+		plan.Print(os.Stdout)
+	default:
+		log.Printf("unknown plan format %q", format)
+		return 2
+	}
+	if !plan.Runnable() {
+		return 1
+	}
+	return 0
+}
+
+// isSpecFile reports whether the positional argument of a plan is a spec file
+func isSpecFile(args []string, bind func(*flag.FlagSet)) bool {
+	probe := flag.NewFlagSet("plan", flag.ContinueOnError)
+	probe.SetOutput(io.Discard)
+	bindRunFlags(probe, &RunSpec{})
+	bind(probe)
+	if err := probe.Parse(args); err != nil || probe.NArg() == 0 {
+		return false
+	}
+	return strings.HasSuffix(probe.Arg(0), ".json")
+}
+
+func newPlan(spec *RunSpec, subnets []Subnet, transitionLefMap map[dsim.TransitionId]int, nodeList []Node) *Plan {
+	plan := &Plan{
+		Model:             spec.Model,
+		PlacementStrategy: spec.Placement.Strategy,
+		Subnets:           make([]PlanSubnet, len(subnets)),
+		Arcs:              []PlanArc{},
+		Links:             []PlanLink{},
+		ZeroLookahead:     [][]string{},
+		linkIndex:         make(map[[2]string]int),
+	}
+
+	placement, err := placeSubnets(subnets, subnetLinks(subnets, transitionLefMap), nodeList, spec.Placement)
+	if err != nil {
+		plan.PlacementError = err.Error()
+	} else {
+		plan.placement = placement
+		plan.CrossHostLinks, _ = placement.CrossHostLinks()
+		plan.InProcessLinks = placement.LocalLinks()
+	}
+
+	for i, subnet := range subnets {
+		ps := PlanSubnet{
+			Name:        subnet.Name,
+			File:        subnet.File,
+			Transitions: len(subnet.Lefs.Network),
+		}
+		if lookahead, err := spec.lookahead(subnet.Lefs); err != nil {
+			ps.LookaheadError = err.Error()
+		} else {
+			ps.Lookahead = lookahead
+		}
+		if placement != nil {
+			ps.Node = placement.Nodes[i].Name
+			ps.Address = placement.Nodes[i].Address
+		}
+		for _, t := range subnet.Lefs.Network {
+			if !t.External {
+				continue
+			}
+			ps.OutputTransitions++
+			for _, p := range t.Propagate {
+				if p.TransitionId >= 0 {
+					continue
+				}
+				arc := PlanArc{
+					Source:                subnet.Name,
+					SourceTransition:      t.Id,
+					DestinationTransition: (1 + p.TransitionId) * -1,
+					Duration:              t.Duration,
+				}
+				j, ok := transitionLefMap[arc.DestinationTransition]
+				if !ok {
+					plan.UnresolvedArcs = append(plan.UnresolvedArcs, arc)
+					continue
+				}
+				if j == i {
+					// Reported as a self-link in the topology errors
+					continue
+				}
+				arc.Destination = subnets[j].Name
+				plan.Arcs = append(plan.Arcs, arc)
+				plan.addArc(arc, i, j)
+			}
+		}
+		plan.Subnets[i] = ps
+	}
+	for i := range plan.Links {
+		source := plan.subnet(plan.Links[i].Source)
+		plan.Links[i].Lookahead = source.Lookahead
+	}
+	sort.Slice(plan.Links, func(i, j int) bool {
+		a, b := plan.Links[i], plan.Links[j]
+		return a.Source < b.Source || a.Source == b.Source && a.Destination < b.Destination
+	})
+	plan.ZeroLookahead = plan.zeroLookaheadCycles()
+
+	segments := make([]dsim.TransitionNode, len(subnets))
+	lefs := make([]dsim.Lefs, len(subnets))
+	for i, subnet := range subnets {
+		segments[i] = dsim.TransitionNode{Name: subnet.Name}
+		lefs[i] = subnet.Lefs
+	}
+	if _, err := dsim.NewTopology(segments, lefs); err != nil {
+		var terr *dsim.TopologyError
+		if errors.As(err, &terr) {
+			plan.TopologyErrors = terr.Problems
+		} else {
+			plan.TopologyErrors = []string{err.Error()}
+		}
+	}
+	return plan
+}
+
+func (plan *Plan) addArc(arc PlanArc, source, destination int) {
+	key := [2]string{arc.Source, arc.Destination}
+	i, ok := plan.linkIndex[key]
+	if !ok {
+		link := PlanLink{Source: arc.Source, Destination: arc.Destination, MinDuration: arc.Duration}
+		if plan.placement != nil {
+			link.CrossHost = plan.placement.Nodes[source].Address != plan.placement.Nodes[destination].Address
+			link.InProcess = plan.placement.Nodes[source].Name == plan.placement.Nodes[destination].Name
+		}
+		plan.Links = append(plan.Links, link)
+		i = len(plan.Links) - 1
+		plan.linkIndex[key] = i
+	}
+	plan.Links[i].Arcs++
+	if arc.Duration < plan.Links[i].MinDuration {
+		plan.Links[i].MinDuration = arc.Duration
+	}
+}
+
+func (plan *Plan) subnet(name string) PlanSubnet {
+	for _, s := range plan.Subnets {
+		if s.Name == name {
+			return s
+		}
+	}
+	return PlanSubnet{}
+}
+
+// zeroLookaheadCycles returns the groups of subnets linked in a cycle where
+// every source announces the zero lookahead the run would send to its
+// engine. Null messages cannot make progress around such a cycle.
+func (plan *Plan) zeroLookaheadCycles() [][]string {
+	graph := make(map[string][]string)
+	for _, link := range plan.Links {
+		if link.Lookahead <= 0 {
+			graph[link.Source] = append(graph[link.Source], link.Destination)
+		}
+	}
+
+	// Tarjan's strongly connected components
+	var (
+		index   = 0
+		indexes = make(map[string]int)
+		lowlink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		cycles  = [][]string{}
+		visit   func(v string)
+	)
+	visit = func(v string) {
+		indexes[v], lowlink[v] = index, index
+		index++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range graph[v] {
+			if _, seen := indexes[w]; !seen {
+				visit(w)
+				if lowlink[w] < lowlink[v] {
+					lowlink[v] = lowlink[w]
+				}
+			} else if onStack[w] && indexes[w] < lowlink[v] {
+				lowlink[v] = indexes[w]
+			}
+		}
+		if lowlink[v] != indexes[v] {
+			return
+		}
+		var component []string
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		if len(component) > 1 {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for _, s := range plan.Subnets {
+		if _, seen := indexes[s.Name]; !seen {
+			visit(s.Name)
+		}
+	}
+	return cycles
+}
+
+// Runnable reports whether the plan can be launched
+func (plan *Plan) Runnable() bool {
+	if plan.PlacementError != "" || len(plan.TopologyErrors) > 0 || len(plan.ZeroLookahead) > 0 {
+		return false
+	}
+	for _, s := range plan.Subnets {
+		if s.LookaheadError != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes the plan as text tables
+func (plan *Plan) Print(out io.Writer) {
+	fmt.Fprintf(out, "Model %s, %d subnets\n\n", plan.Model, len(plan.Subnets))
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBNET\tTRANSITIONS\tOUTPUTS\tLOOKAHEAD\tNODE\tADDRESS")
+	for _, s := range plan.Subnets {
+		lookahead := fmt.Sprint(s.Lookahead)
+		if s.LookaheadError != "" {
+			lookahead = "error"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", s.Name, s.Transitions, s.OutputTransitions, lookahead, dash(s.Node), dash(s.Address))
+	}
+	w.Flush()
+	for _, s := range plan.Subnets {
+		if s.LookaheadError != "" {
+			fmt.Fprintf(out, "%s: %s\n", s.Name, s.LookaheadError)
+		}
+	}
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tARCS\tLOOKAHEAD\tMIN DURATION\tTRANSPORT")
+	for _, l := range plan.Links {
+		transport := "network"
+		switch {
+		case plan.placement == nil:
+			transport = "-"
+		case l.InProcess:
+			transport = "in-process"
+		case l.CrossHost:
+			transport = "cross-host"
+		}
+		fmt.Fprintf(w, "%s -> %s\t%d\t%v\t%v\t%s\n", l.Source, l.Destination, l.Arcs, l.Lookahead, l.MinDuration, transport)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ARC\tFROM\tTO\tDURATION")
+	for _, a := range plan.Arcs {
+		fmt.Fprintf(w, "%s -> %s\t%d\t%d\t%v\n", a.Source, a.Destination, a.SourceTransition, a.DestinationTransition, a.Duration)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	if plan.PlacementError != "" {
+		fmt.Fprintf(out, "Placement (%s) failed: %s\n", plan.PlacementStrategy, plan.PlacementError)
+	} else {
+		fmt.Fprintf(out, "Placement (%s): %d cross-host links, %d in-process links of %d\n",
+			plan.PlacementStrategy, plan.CrossHostLinks, plan.InProcessLinks, len(plan.Arcs))
+	}
+	for _, problem := range plan.TopologyErrors {
+		fmt.Fprintf(out, "Topology error: %s\n", problem)
+	}
+	for _, c := range plan.ZeroLookahead {
+		fmt.Fprintf(out, "Zero lookahead cycle: %s\n", strings.Join(c, ", "))
+	}
+	if plan.Runnable() {
+		fmt.Fprintln(out, "Plan is runnable")
+	} else {
+		fmt.Fprintln(out, "Plan is NOT runnable")
+	}
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// nodeRegistry collects the registrations of the nodes launched with
+// -register, which announce the endpoint they listen on and their
+// capabilities. While joining, daemons missing from the node list register
+// to take part in the run.
+type nodeRegistry struct {
+	mu       sync.Mutex
+	expected map[string]chan dsim.RegisterNodeRequest
+	joining  bool
+	listed   map[string]bool
+	joined   []dsim.RegisterNodeRequest
+}
+
+func newNodeRegistry() *nodeRegistry {
+	return &nodeRegistry{expected: make(map[string]chan dsim.RegisterNodeRequest)}
+}
+
+// expect accepts the registration of the named node
+func (r *nodeRegistry) expect(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expected[name] = make(chan dsim.RegisterNodeRequest, 1)
+}
+
+// openJoin accepts the registrations of daemons missing from nodeList
+func (r *nodeRegistry) openJoin(nodeList []Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.joining = true
+	r.listed = make(map[string]bool, len(nodeList))
+	for _, node := range nodeList {
+		r.listed[node.Name] = true
+	}
+}
+
+// closeJoin rejects the registrations of daemons missing from the node list
+// again and returns those that joined, in registration order
+func (r *nodeRegistry) closeJoin() []dsim.RegisterNodeRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.joining = false
+	return r.joined
+}
+
+// register records a registration, completing its address with the remote
+// host when the node listens on every interface
+func (r *nodeRegistry) register(mt dsim.RegisterNodeRequest, remote net.Addr) *communicator.Error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	registered, ok := r.expected[mt.Node]
+	if !ok && !r.joining {
+		return communicator.NewError(dsim.ErrUnknownNode, "node %s is not launched by this launcher", mt.Node).WithDetail("node", mt.Node)
+	}
+	host, port, err := net.SplitHostPort(dsim.ResolveAddress(net.JoinHostPort(mt.Address, mt.Port), remote))
+	if err != nil {
+		return communicator.NewError(dsim.ErrUnknownNode, "node %s announced an invalid endpoint: %s", mt.Node, err).WithDetail("node", mt.Node)
+	}
+	mt.Address, mt.Port = host, port
+	if !ok {
+		return r.join(mt)
+	}
+	select {
+	case registered <- mt:
+		return nil
+	default:
+		return communicator.NewError(dsim.ErrUnknownNode, "node %s is already registered", mt.Node).WithDetail("node", mt.Node)
+	}
+}
+
+// join adds a daemon missing from the node list to the run
+func (r *nodeRegistry) join(mt dsim.RegisterNodeRequest) *communicator.Error {
+	if !mt.Capabilities.Daemon {
+		return communicator.NewError(dsim.ErrUnknownNode, "node %s must be a daemon to join the run", mt.Node).WithDetail("node", mt.Node)
+	}
+	if r.listed[mt.Node] {
+		return communicator.NewError(dsim.ErrUnknownNode, "node %s is in the node list", mt.Node).WithDetail("node", mt.Node)
+	}
+	for _, joined := range r.joined {
+		if joined.Node == mt.Node {
+			return communicator.NewError(dsim.ErrUnknownNode, "node %s is already registered", mt.Node).WithDetail("node", mt.Node)
+		}
+	}
+	r.joined = append(r.joined, mt)
+	return nil
+}
+
+// joinedNode is the node of a daemon that joined the run. It is placed
+// according to its number of cpus.
+func joinedNode(mt dsim.RegisterNodeRequest) Node {
+	node := Node{
+		Name:    mt.Node,
+		Address: mt.Address,
+		Port:    mt.Port,
+		Backend: DaemonBackendName,
+	}
+	if mt.Capabilities.CPUs > 0 {
+		node.Capacity = float64(mt.Capabilities.CPUs)
+	}
+	return node
+}
+
+// wait returns the registration of the named node, failing after timeout
+func (r *nodeRegistry) wait(name string, timeout time.Duration) (dsim.RegisterNodeRequest, error) {
+	r.mu.Lock()
+	registered, ok := r.expected[name]
+	r.mu.Unlock()
+	if !ok {
+		return dsim.RegisterNodeRequest{}, fmt.Errorf("node %s is not expected to register", name)
+	}
+	select {
+	case mt := <-registered:
+		// Keep the registration for a repeated wait
+		registered <- mt
+		return mt, nil
+	case <-time.After(timeout):
+		return dsim.RegisterNodeRequest{}, fmt.Errorf("node %s did not register after %s", name, timeout)
+	}
+}
+
+// registerAddress returns the launcher address announced to a node: the
+// listen address when it names a host, otherwise the local address used to
+// reach the node host
+func registerAddress(listen net.Addr, node Node) string {
+	host, port, err := net.SplitHostPort(listen.String())
+	if err != nil {
+		return listen.String()
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return listen.String()
+	}
+	// Connecting an udp socket sends nothing, it only selects the route
+	conn, err := net.Dial("udp", net.JoinHostPort(node.Address, "9"))
+	if err != nil {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	defer conn.Close()
+	local, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return net.JoinHostPort(local, port)
+}
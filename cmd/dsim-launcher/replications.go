@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// derive returns a copy of the spec for one run of a series, with the run
+// id and its own directories under those of the series
+func (spec *RunSpec) derive(run string) *RunSpec {
+	derived := *spec
+	derived.Nodes = append([]Node(nil), spec.Nodes...)
+	derived.Replications = 1
+	derived.RunId = run
+	derived.LogsDir = filepath.Join(spec.LogsDir, run)
+	derived.ResultsDir = filepath.Join(spec.ResultsDir, run)
+	return &derived
+}
+
+// replica returns the spec of replication i of a run, with its own seed, run
+// id and directories
+func (spec *RunSpec) replica(run dsim.RunId, i int) *RunSpec {
+	replica := spec.derive(fmt.Sprintf("%s-r%d", run, i+1))
+	replica.Seed = spec.Seed + int64(i)
+	return replica
+}
+
+// validateParallel checks a series of runs executed Parallel at a time do
+// not compete for the ports of launched nodes nor for the launcher one.
+// Daemons serve several runs at a time.
+func (spec *RunSpec) validateParallel(runs int) error {
+	if spec.Parallel < 2 || runs < 2 {
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(spec.Listen); err != nil || (port != "0" && port != "") {
+		return fmt.Errorf("parallel runs need the launcher to listen on an ephemeral port, not %q", spec.Listen)
+	}
+	nodeList, err := spec.nodeList()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodeList {
+		if node.Port != "0" && node.backend() != DaemonBackendName {
+			return fmt.Errorf("parallel runs need node %s to listen on an ephemeral port or to be a daemon", node.Name)
+		}
+	}
+	return nil
+}
+
+// runSeries runs the specs, parallel of them at a time, and returns their
+// statistics and errors in the same order, nil statistics for failed runs.
+// A failed run is reported and does not stop the others.
+func runSeries(specs []*RunSpec, parallel int) ([]*dsim.RunStatistics, []error) {
+	stats := make([]*dsim.RunStatistics, len(specs))
+	errs := make([]error, len(specs))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, spec *RunSpec) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			stats[i], errs[i] = runSimulation(spec)
+			if errs[i] != nil {
+				fmt.Fprintf(os.Stderr, "Run %s failed: %s\n", spec.RunId, errs[i])
+			}
+		}(i, spec)
+	}
+	wg.Wait()
+	return stats, errs
+}
+
+// runReplications runs the replications of the spec, Parallel of them at a
+// time, and estimates the measures of the model from their statistics. It
+// returns the exit status of the launcher.
+func runReplications(spec *RunSpec) int {
+	if err := spec.validateParallel(spec.Replications); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	run := spec.runId()
+	fmt.Printf("Run %s: %d replications, %d at a time\n", run, spec.Replications, spec.Parallel)
+	clog := newLauncherLog(spec)
+	defer clog.Close()
+	clog.LogInfof("Starting %d replications of run %s", spec.Replications, run)
+
+	replicas := make([]*RunSpec, spec.Replications)
+	for i := range replicas {
+		replicas[i] = spec.replica(run, i)
+	}
+	stats, errs := runSeries(replicas, spec.Parallel)
+	var runs []dsim.RunStatistics
+	for i, s := range stats {
+		if errs[i] != nil {
+			clog.LogErrorf("Replication %d failed, it is left out of the estimates: %s", i+1, errs[i])
+			continue
+		}
+		runs = append(runs, *s)
+	}
+	if len(runs) < 2 {
+		fmt.Fprintf(os.Stderr, "%d replications with statistics, at least 2 are needed\n", len(runs))
+		return 1
+	}
+	estimates := dsim.EstimateReplications(runs, spec.Confidence)
+	estimates.Print(os.Stdout)
+	writeResult(clog, spec.ResultsDir, fmt.Sprintf("%s-replications.json", run), "Replication estimates", estimates)
+	return 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+)
+
+// Extensions of the ShiViz streams written with -shiviz and the JSON logs
+// written with -jsonLogs
+const (
+	shivizFileExt  = ".shiviz"
+	jsonLogFileExt = ".jsonl"
+)
+
+// shivizCommand merges the logs of a run into a single ShiViz log
+func shivizCommand(args []string) int {
+	spec, err := defaultRunSpec()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	flags := flag.NewFlagSet("shiviz", flag.ExitOnError)
+	flags.StringVar(&spec.LogsDir, "logsDir", spec.LogsDir, "The directory of the launcher and node logs")
+	var output string
+	flags.StringVar(&output, "o", "", "The merged ShiViz log, shiviz.log in the logs directory when empty")
+	flags.Parse(args)
+	if output == "" {
+		output = filepath.Join(spec.LogsDir, "shiviz.log")
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		if files, err = runLogFiles(spec.LogsDir, output); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	var entries []clock.LogEntry
+	for _, file := range files {
+		fileEntries, err := readLogFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		entries = append(entries, fileEntries...)
+	}
+	// Logs written with -hlc are merged in hybrid logical clock order
+	sorted := clock.SortByHLC(entries)
+
+	f, err := os.Create(output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	if err := clock.WriteShiViz(f, entries); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	order := "log"
+	if sorted {
+		order = "hybrid logical clock"
+	}
+	fmt.Printf("Merged %d events of %d logs into %s in %s order\n", len(entries), len(files), output, order)
+	return 0
+}
+
+// runLogFiles returns the ShiViz streams of the logs directory or, without
+// streams, the launcher and node logs. Process output logs are skipped.
+func runLogFiles(logsDir, output string) ([]string, error) {
+	streams, err := filepath.Glob(filepath.Join(logsDir, "*"+shivizFileExt))
+	if err != nil || len(streams) > 0 {
+		return streams, err
+	}
+	logs, err := filepath.Glob(filepath.Join(logsDir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(logs))
+	for _, file := range logs {
+		name := filepath.Base(file)
+		if isProcessLog(name) || file == output || name == "shiviz.log" {
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no logs found in %s", logsDir)
+	}
+	return files, nil
+}
+
+// readLogFile reads the events of a text log, ShiViz stream or JSON log
+func readLogFile(file string) ([]clock.LogEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if filepath.Ext(file) == jsonLogFileExt {
+		records, err := clock.ReadJSONLog(f, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		entries := make([]clock.LogEntry, len(records))
+		for i, record := range records {
+			entries[i] = record.Entry()
+		}
+		return entries, nil
+	}
+	read := clock.ReadLog
+	if filepath.Ext(file) == shivizFileExt {
+		read = clock.ReadShiViz
+	}
+	entries, err := read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// Lookahead policies of a run spec
+const (
+	// LookaheadConstant uses the lookahead value for every subnet
+	LookaheadConstant = "constant"
+	// LookaheadMinDuration uses the minimum firing duration of the output
+	// transitions of every subnet, the earliest a subnet can send an event
+	LookaheadMinDuration = "minDuration"
+)
+
+// SynchronizationConservative is the null message synchronization of the
+// nodes, the only one supported so far
+const SynchronizationConservative = "conservative"
+
+// Vector clock modes of a run spec
+const (
+	// VectorClocksFull sends the whole vector clock with every message
+	VectorClocksFull = "full"
+	// VectorClocksCompact sends the changed entries of the clocks of events
+	// and null messages, indexed by a table sent when the run is prepared
+	VectorClocksCompact = "compact"
+	// VectorClocksOff disables vector clocks for performance runs, logs
+	// cannot be checked nor visualized with ShiViz
+	VectorClocksOff = "off"
+)
+
+// LookaheadSpec selects how the lookahead of every subnet is computed
+type LookaheadSpec struct {
+	Policy string  `json:"policy"`
+	Value  float64 `json:"value"`
+}
+
+// RunSpec describes a simulation run. It is loaded from the JSON file given
+// to dsim-launcher run, and launcher flags override its fields.
+type RunSpec struct {
+	// RunId identifies the run on the nodes, generated when empty
+	RunId string `json:"runId,omitempty"`
+	// Model is the path prefix of the subnet files <model>.subred<i>.json
+	Model string `json:"model"`
+	// NodeFile lists the simulation nodes when Nodes is empty
+	NodeFile string `json:"nodeFile,omitempty"`
+	Nodes    []Node `json:"nodes,omitempty"`
+	// Backend launches the nodes not setting one of their own
+	Backend   string     `json:"backend,omitempty"`
+	NodeCmd   string     `json:"nodeCmd,omitempty"`
+	Deploy    string     `json:"deploy,omitempty"`
+	DeployDir string     `json:"deployDir,omitempty"`
+	SSH       SSHOptions `json:"ssh"`
+	// Placement selects the node of every subnet
+	Placement PlacementSpec `json:"placement"`
+	// End is the simulated time at which the simulation stops
+	End             float64       `json:"end"`
+	Lookahead       LookaheadSpec `json:"lookahead"`
+	Synchronization string        `json:"synchronization"`
+	VectorClocks    string        `json:"vectorClocks"`
+	LogsDir         string        `json:"logsDir"`
+	ResultsDir      string        `json:"resultsDir"`
+	LogLevel        string        `json:"logLevel"`
+	NodeLogLevel    string        `json:"nodeLogLevel"`
+	// Durations is the distribution of the firing durations, deterministic
+	// or shiftedExponential, drawn from streams seeded with Seed
+	Durations string `json:"durations"`
+	Seed      int64  `json:"seed"`
+	// WarmUp is the simulated time discarded from the statistics
+	WarmUp float64 `json:"warmUp,omitempty"`
+	// Batches splits the measured period of a run into batches for a batch
+	// means analysis
+	Batches int `json:"batches,omitempty"`
+	// Replications runs the model that many times with distinct seeds,
+	// Parallel of them at a time
+	Replications int `json:"replications"`
+	Parallel     int `json:"parallel"`
+	// Confidence is the level of the confidence intervals of the estimates
+	Confidence float64 `json:"confidence"`
+	// ShiViz writes a ShiViz stream of every log next to it
+	ShiViz bool `json:"shiviz,omitempty"`
+	// JSONLogs writes a JSON Lines log next to every log
+	JSONLogs bool `json:"jsonLogs,omitempty"`
+	// HLCStamps stamps the logged events with hybrid logical clocks, the
+	// merged ShiViz log is then sorted by them
+	HLCStamps bool `json:"hlcStamps,omitempty"`
+	// Trace writes a Chrome trace of every launched node next to its log
+	Trace bool `json:"trace,omitempty"`
+	// Listen is the launcher address for node registrations and failure
+	// reports
+	Listen string `json:"listen"`
+	// RegisterTimeout is the time in seconds given to launched nodes to
+	// register with the launcher
+	RegisterTimeout int `json:"registerTimeout"`
+	// JoinWindow is the time in seconds the launcher accepts daemons missing
+	// from the node list, started with dsim-node -daemon -register, before
+	// placing the subnets. None join when 0.
+	JoinWindow int `json:"joinWindow,omitempty"`
+	// transitionFields override fields of the model transitions, such as
+	// those set by the points of a sweep
+	transitionFields []transitionField
+}
+
+// defaultRunSpec returns the spec of a run without spec file nor flags
+func defaultRunSpec() (*RunSpec, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	return &RunSpec{
+		NodeFile:  "simulation-nodes.json",
+		Backend:   SSHBackendName,
+		DeployDir: ".cache/dsim",
+		SSH: SSHOptions{
+			User:       currentUser.Username,
+			SSHPort:    22,
+			KnownHosts: filepath.Join(home, ".ssh", "known_hosts"),
+		},
+		Placement: PlacementSpec{
+			Strategy: PlacementRoundRobin,
+		},
+		End:          10,
+		Durations:    string(dsim.DurationsDeterministic),
+		Seed:         1,
+		Replications: 1,
+		Parallel:     1,
+		Confidence:   0.95,
+		Lookahead: LookaheadSpec{
+			Policy: LookaheadConstant,
+			Value:  1,
+		},
+		Synchronization: SynchronizationConservative,
+		VectorClocks:    VectorClocksFull,
+		LogsDir:         filepath.Join(home, "dsim", "logs"),
+		ResultsDir:      filepath.Join(home, "dsim", "results"),
+		LogLevel:        clock.DEBUG.String(),
+		NodeLogLevel:    clock.DEBUG.String(),
+		Listen:          ":0",
+		RegisterTimeout: 30,
+	}, nil
+}
+
+// loadRunSpec reads the spec file over the values already in spec
+func loadRunSpec(path string, spec *RunSpec) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return fmt.Errorf("invalid run spec %s: %w", path, err)
+	}
+	// Relative paths in the spec are relative to the spec file
+	dir := filepath.Dir(path)
+	for _, p := range []*string{&spec.Model, &spec.NodeFile, &spec.NodeCmd, &spec.Deploy} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return nil
+}
+
+// bindRunFlags registers the launcher flags on fs using the spec values as
+// defaults, so only the flags given on the command line change the spec.
+func bindRunFlags(fs *flag.FlagSet, spec *RunSpec) {
+	fs.StringVar(&spec.RunId, "runId", spec.RunId, "The run id, generated when empty")
+	fs.StringVar(&spec.NodeFile, "nodeFile", spec.NodeFile, "The simulation nodes list")
+	fs.StringVar(&spec.Backend, "backend", spec.Backend, "The launch backend of nodes without one (ssh, exec or daemon)")
+	fs.StringVar(&spec.NodeCmd, "nodeCmd", spec.NodeCmd, "The simulation node exec")
+	fs.StringVar(&spec.Deploy, "deploy", spec.Deploy, "Upload the dsim-node build for every ssh host from this path, {arch} is replaced by the host architecture")
+	fs.StringVar(&spec.DeployDir, "deployDir", spec.DeployDir, "The remote cache directory for deployed dsim-node builds")
+	fs.StringVar(&spec.Placement.Strategy, "placement", spec.Placement.Strategy, "The placement strategy of subnets not assigned in the spec (roundRobin, capacity or minCrossHost)")
+	fs.Float64Var(&spec.End, "period", spec.End, "The simulation period")
+	fs.StringVar(&spec.Durations, "durations", spec.Durations, "The distribution of the firing durations: deterministic, or shiftedExponential for the LEF duration plus an exponential delay of the same mean")
+	fs.Int64Var(&spec.Seed, "seed", spec.Seed, "The seed of the random firing durations, replications use the following seeds")
+	fs.Float64Var(&spec.WarmUp, "warmUp", spec.WarmUp, "The simulated time discarded from the statistics")
+	fs.IntVar(&spec.Batches, "batches", spec.Batches, "The batches of the batch means analysis of a run, none when lower than 2")
+	fs.IntVar(&spec.Replications, "replications", spec.Replications, "The independent replications of the run")
+	fs.IntVar(&spec.Parallel, "parallel", spec.Parallel, "The replications run at a time")
+	fs.Float64Var(&spec.Confidence, "confidence", spec.Confidence, "The level of the confidence intervals")
+	fs.StringVar(&spec.Lookahead.Policy, "lookaheadPolicy", spec.Lookahead.Policy, "The lookahead policy (constant or minDuration)")
+	fs.Float64Var(&spec.Lookahead.Value, "lookahead", spec.Lookahead.Value, "The constant lookahead")
+	fs.StringVar(&spec.Synchronization, "sync", spec.Synchronization, "The synchronization mode")
+	fs.StringVar(&spec.VectorClocks, "vectorClocks", spec.VectorClocks, "The vector clocks of messages (full, compact or off)")
+	fs.StringVar(&spec.LogsDir, "logsDir", spec.LogsDir, "The directory of the launcher and node logs")
+	fs.StringVar(&spec.ResultsDir, "resultsDir", spec.ResultsDir, "The directory of the node results")
+	fs.StringVar(&spec.LogLevel, "logLevel", spec.LogLevel, "The minimum priority of launcher log messages")
+	fs.StringVar(&spec.NodeLogLevel, "nodeLogLevel", spec.NodeLogLevel, "The minimum priority of node log messages")
+	fs.BoolVar(&spec.ShiViz, "shiviz", spec.ShiViz, "Write a ShiViz stream of the launcher and node logs")
+	fs.BoolVar(&spec.JSONLogs, "jsonLogs", spec.JSONLogs, "Write a JSON Lines log of the launcher and the nodes")
+	fs.BoolVar(&spec.Trace, "trace", spec.Trace, "Write a Chrome trace of the launched nodes")
+	fs.BoolVar(&spec.HLCStamps, "hlc", spec.HLCStamps, "Stamp the logged events of the launcher and the nodes with hybrid logical clocks")
+	fs.StringVar(&spec.Listen, "listen", spec.Listen, "The launcher listen address for node registrations and failure reports")
+	fs.IntVar(&spec.RegisterTimeout, "registerTimeout", spec.RegisterTimeout, "The seconds given to launched nodes to register with the launcher")
+	fs.IntVar(&spec.JoinWindow, "joinWindow", spec.JoinWindow, "The seconds to accept daemons missing from the node list registering with the launcher before placing the subnets, their cpus are their capacity")
+	fs.StringVar(&spec.SSH.User, "sshUser", spec.SSH.User, "The ssh user for the node hosts")
+	fs.IntVar(&spec.SSH.SSHPort, "sshPort", spec.SSH.SSHPort, "The ssh port of the node hosts")
+	fs.StringVar(&spec.SSH.IdentityFile, "sshIdentity", spec.SSH.IdentityFile, "The ssh private key file, the ssh-agent is used when empty")
+	fs.StringVar(&spec.SSH.KnownHosts, "sshKnownHosts", spec.SSH.KnownHosts, "The known_hosts file to verify host keys")
+	fs.Var(optionalBool{&spec.SSH.TrustOnFirstUse}, "sshTrustOnFirstUse", "Accept and record host keys of hosts missing from known_hosts")
+}
+
+// optionalBool is a boolean flag that stays nil unless it is given
+type optionalBool struct {
+	p **bool
+}
+
+func (b optionalBool) String() string {
+	if b.p == nil || *b.p == nil {
+		return "false"
+	}
+	return fmt.Sprint(**b.p)
+}
+
+func (b optionalBool) Set(value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*b.p = &v
+	return nil
+}
+
+func (b optionalBool) IsBoolFlag() bool { return true }
+
+// parseRunSpec builds the run spec from the command line. With fromFile the
+// first positional argument is a spec file, otherwise it is the model.
+// Flags must precede positional arguments. bind registers the flags of the
+// command besides the run flags when not nil.
+func parseRunSpec(name string, args []string, fromFile bool, bind func(*flag.FlagSet)) (*RunSpec, error) {
+	spec, err := defaultRunSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	if fromFile {
+		// Find the spec file first, flags are applied over its content.
+		// Flag errors are reported by the second parse.
+		probe := flag.NewFlagSet(name, flag.ContinueOnError)
+		probe.SetOutput(io.Discard)
+		bindRunFlags(probe, &RunSpec{})
+		if bind != nil {
+			bind(probe)
+		}
+		if err := probe.Parse(args); err == nil {
+			if probe.NArg() == 0 {
+				return nil, fmt.Errorf("usage: dsim-launcher %s [flags] spec.json", name)
+			}
+			if err := loadRunSpec(probe.Arg(0), spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	bindRunFlags(fs, spec)
+	if bind != nil {
+		bind(fs)
+	}
+	fs.Parse(args)
+	if !fromFile && fs.NArg() > 0 {
+		spec.Model = fs.Arg(0)
+	}
+	return spec, spec.validate()
+}
+
+func (spec *RunSpec) validate() error {
+	if spec.Model == "" {
+		return errors.New("no model given")
+	}
+	if spec.End <= 0 {
+		return fmt.Errorf("invalid simulation end %v", spec.End)
+	}
+	if err := dsim.DurationDistribution(spec.Durations).Validate(); err != nil {
+		return err
+	}
+	if spec.WarmUp < 0 || spec.WarmUp >= spec.End {
+		return fmt.Errorf("warm-up %v must be within the simulation period %v", spec.WarmUp, spec.End)
+	}
+	if spec.Batches < 0 {
+		return fmt.Errorf("invalid batches %d", spec.Batches)
+	}
+	if spec.Replications < 1 || spec.Parallel < 1 {
+		return fmt.Errorf("invalid %d replications, %d at a time", spec.Replications, spec.Parallel)
+	}
+	if spec.Confidence <= 0 || spec.Confidence >= 1 {
+		return fmt.Errorf("confidence level %v must be between 0 and 1", spec.Confidence)
+	}
+	if spec.RegisterTimeout <= 0 {
+		return fmt.Errorf("invalid register timeout %d", spec.RegisterTimeout)
+	}
+	if spec.JoinWindow < 0 {
+		return fmt.Errorf("invalid join window %d", spec.JoinWindow)
+	}
+	if spec.JoinWindow > 0 && spec.Replications > 1 {
+		return errors.New("daemons register once, they cannot join replications")
+	}
+	if spec.Synchronization != SynchronizationConservative {
+		return fmt.Errorf("unsupported synchronization mode %q, only %q is available", spec.Synchronization, SynchronizationConservative)
+	}
+	switch spec.VectorClocks {
+	case VectorClocksFull, VectorClocksCompact, VectorClocksOff:
+	default:
+		return fmt.Errorf("unknown vector clocks mode %q", spec.VectorClocks)
+	}
+	switch spec.Lookahead.Policy {
+	case LookaheadConstant:
+		if spec.Lookahead.Value <= 0 {
+			return fmt.Errorf("constant lookahead must be positive, got %v", spec.Lookahead.Value)
+		}
+	case LookaheadMinDuration:
+		if spec.Lookahead.Value <= 0 {
+			return fmt.Errorf("lookahead for subnets without output transitions must be positive, got %v", spec.Lookahead.Value)
+		}
+	default:
+		return fmt.Errorf("unknown lookahead policy %q", spec.Lookahead.Policy)
+	}
+	switch spec.Placement.Strategy {
+	case PlacementRoundRobin, PlacementCapacity, PlacementMinCrossHost:
+	default:
+		return fmt.Errorf("unknown placement strategy %q", spec.Placement.Strategy)
+	}
+	if _, err := clock.ParseLogPriority(spec.LogLevel); err != nil {
+		return err
+	}
+	if _, err := clock.ParseLogPriority(spec.NodeLogLevel); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateLaunch checks the spec can start the nodes of a run
+func (spec *RunSpec) validateLaunch() error {
+	if spec.NodeCmd == "" && spec.Deploy == "" {
+		return errors.New("either a node command or a deploy path is required")
+	}
+	return nil
+}
+
+// nodeList returns the nodes of the run with their launch backend set
+func (spec *RunSpec) nodeList() ([]Node, error) {
+	nodeList := spec.Nodes
+	if len(nodeList) == 0 {
+		var err error
+		if nodeList, err = loadNodesFromFile(spec.NodeFile); err != nil {
+			return nil, err
+		}
+	}
+	for i := range nodeList {
+		if nodeList[i].Backend == "" {
+			nodeList[i].Backend = spec.Backend
+		}
+		// Launched nodes without port listen on an ephemeral one and
+		// register it with the launcher
+		if nodeList[i].Port == "" {
+			nodeList[i].Port = "0"
+		}
+		if nodeList[i].Port == "0" && nodeList[i].Backend == DaemonBackendName {
+			return nil, fmt.Errorf("daemon %s needs the port it listens on", nodeList[i].Name)
+		}
+	}
+	return nodeList, nil
+}
+
+// runId returns the spec run id, generating a new one when it is empty
+func (spec *RunSpec) runId() dsim.RunId {
+	if spec.RunId == "" {
+		now := time.Now()
+		suffix := rand.New(rand.NewSource(now.UnixNano())).Intn(0x10000)
+		spec.RunId = fmt.Sprintf("%s-%04x", now.Format("20060102-150405"), suffix)
+	}
+	return dsim.RunId(spec.RunId)
+}
+
+// lookahead returns the lookahead of a subnet according to the spec policy
+func (spec *RunSpec) lookahead(lef dsim.Lefs) (dsim.Clock, error) {
+	if spec.Lookahead.Policy == LookaheadConstant {
+		return dsim.Clock(spec.Lookahead.Value), nil
+	}
+
+	lookahead := dsim.Clock(-1)
+	for _, t := range lef.Network {
+		if t.External && (lookahead < 0 || t.Duration < lookahead) {
+			lookahead = t.Duration
+		}
+	}
+	switch {
+	case lookahead < 0:
+		// A subnet without output transitions never sends events
+		return dsim.Clock(spec.Lookahead.Value), nil
+	case lookahead == 0:
+		return 0, errors.New("subnet has output transitions without firing duration, zero lookahead would deadlock")
+	}
+	return lookahead, nil
+}
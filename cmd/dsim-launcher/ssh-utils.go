@@ -1,15 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 type SSHCommand struct {
@@ -36,6 +40,15 @@ func (client *SSHClient) RunCommand(session *ssh.Session, cmd *SSHCommand) error
 	return err
 }
 
+// StartCommand starts cmd in session without waiting for it to finish
+func (client *SSHClient) StartCommand(session *ssh.Session, cmd *SSHCommand) error {
+	if err := client.prepareCommand(session, cmd); err != nil {
+		return err
+	}
+
+	return session.Start(cmd.Path)
+}
+
 func (client *SSHClient) prepareCommand(session *ssh.Session, cmd *SSHCommand) error {
 	for _, env := range cmd.Env {
 		variable := strings.Split(env, "=")
@@ -75,11 +88,19 @@ func (client *SSHClient) prepareCommand(session *ssh.Session, cmd *SSHCommand) e
 	return nil
 }
 
-func (client *SSHClient) newSession() (*ssh.Session, error) {
-	connection, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", client.Host, client.Port), client.Config)
+func (client *SSHClient) dial() (*ssh.Client, error) {
+	connection, err := ssh.Dial("tcp", net.JoinHostPort(client.Host, fmt.Sprint(client.Port)), client.Config)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to dial: %s", err)
 	}
+	return connection, nil
+}
+
+func (client *SSHClient) newSession() (*ssh.Session, error) {
+	connection, err := client.dial()
+	if err != nil {
+		return nil, err
+	}
 
 	session, err := connection.NewSession()
 	if err != nil {
@@ -100,23 +121,220 @@ func (client *SSHClient) newSession() (*ssh.Session, error) {
 	return session, nil
 }
 
-func PublicKeyFile(file string) ssh.AuthMethod {
-	var data []byte
-	var err error
-	if data, err = os.ReadFile(file); err != nil {
-		log.Fatalf(err.Error())
+// SSHOptions configures how the launcher connects to a node host. Every
+// field left empty in a node file entry takes the launcher flag value.
+type SSHOptions struct {
+	User         string `json:"user,omitempty"`
+	SSHPort      int    `json:"sshPort,omitempty"`
+	IdentityFile string `json:"identityFile,omitempty"`
+	KnownHosts   string `json:"knownHosts,omitempty"`
+	// TrustOnFirstUse is a pointer so a node can turn off the launcher
+	// default with false
+	TrustOnFirstUse *bool `json:"trustOnFirstUse,omitempty"`
+}
+
+func (o SSHOptions) withDefaults(defaults SSHOptions) SSHOptions {
+	if o.User == "" {
+		o.User = defaults.User
+	}
+	if o.SSHPort == 0 {
+		o.SSHPort = defaults.SSHPort
+	}
+	if o.IdentityFile == "" {
+		o.IdentityFile = defaults.IdentityFile
+	}
+	if o.KnownHosts == "" {
+		o.KnownHosts = defaults.KnownHosts
+	}
+	if o.TrustOnFirstUse == nil {
+		o.TrustOnFirstUse = defaults.TrustOnFirstUse
+	}
+	return o
+}
+
+// trustOnFirstUse tells whether unknown host keys are accepted and recorded
+func (o SSHOptions) trustOnFirstUse() bool {
+	return o.TrustOnFirstUse != nil && *o.TrustOnFirstUse
+}
+
+// clientConfig builds the ssh client configuration for the options. The
+// closer releases the connection to the ssh-agent once the clients using
+// the configuration are authenticated.
+func (o SSHOptions) clientConfig() (*ssh.ClientConfig, io.Closer, error) {
+	var (
+		auth   ssh.AuthMethod
+		closer io.Closer = io.NopCloser(nil)
+		err    error
+	)
+	if o.IdentityFile != "" {
+		auth, err = PublicKeyFile(o.IdentityFile)
+	} else {
+		auth, closer, err = SSHAgent()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostKeyCallback, err := KnownHostsCallback(o.KnownHosts, o.trustOnFirstUse())
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            o.User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+	}, closer, nil
+}
+
+// SSHBackend starts simulation nodes on remote hosts through ssh
+type SSHBackend struct {
+	Defaults SSHOptions
+	Stdin    io.Reader
+	// Deployer uploads the node binary before launching when not nil
+	Deployer *Deployer
+}
+
+type sshProcess struct {
+	session *ssh.Session
+}
+
+func (b *SSHBackend) Launch(node Node, cmd *NodeCommand) (NodeProcess, error) {
+	options := node.SSHOptions.withDefaults(b.Defaults)
+	config, agentConnection, err := options.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Every connection of the launch is authenticated before it returns
+	defer agentConnection.Close()
+
+	client := &SSHClient{
+		Config: config,
+		Host:   node.Address,
+		Port:   options.SSHPort,
+	}
+
+	path := cmd.Path
+	if b.Deployer != nil {
+		connection, err := client.dial()
+		if err != nil {
+			return nil, err
+		}
+		path, err = b.Deployer.Deploy(connection, node.Address)
+		connection.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	command := &NodeCommand{Path: path, Args: cmd.Args}
+
+	session, err := client.newSession()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.StartCommand(session, &SSHCommand{
+		Path:   command.String(),
+		Stdin:  b.Stdin,
+		Stdout: cmd.Stdout,
+		Stderr: cmd.Stderr,
+	}); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &sshProcess{session: session}, nil
+}
+
+func (p *sshProcess) Wait() error {
+	return p.session.Wait()
+}
+
+func (p *sshProcess) Interrupt() error {
+	return p.session.Signal(ssh.SIGINT)
+}
+
+func (p *sshProcess) Kill() error {
+	p.session.Signal(ssh.SIGKILL)
+	return p.session.Close()
+}
+
+func PublicKeyFile(file string) (ssh.AuthMethod, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %w", err)
 	}
 
 	key, err := ssh.ParsePrivateKey(data)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("failed to parse identity file %s: %w", file, err)
 	}
-	return ssh.PublicKeys(key)
+	return ssh.PublicKeys(key), nil
 }
 
-func SSHAgent() ssh.AuthMethod {
-	if sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
-		return ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers)
+// SSHAgent authenticates with the keys of the ssh-agent. The returned
+// connection to the agent must be closed once authentication is done.
+func SSHAgent() (ssh.AuthMethod, io.Closer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set: start an ssh-agent or give an identity file")
 	}
-	return nil
+	sshAgent, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent at %s: %w", socket, err)
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers), sshAgent, nil
+}
+
+// knownHostsMutex serializes trust on first use updates of known_hosts files
+var knownHostsMutex sync.Mutex
+
+// KnownHostsCallback verifies host keys against the known_hosts file at path.
+// With trustOnFirstUse, keys of hosts missing from the file are accepted and
+// appended to it, while a changed key is still rejected.
+func KnownHostsCallback(path string, trustOnFirstUse bool) (ssh.HostKeyCallback, error) {
+	if _, err := os.Stat(path); err != nil {
+		if !errors.Is(err, os.ErrNotExist) || !trustOnFirstUse {
+			return nil, fmt.Errorf("known_hosts file %s not available (use trust on first use to create it): %w", path, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts file %s: %w", path, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("host key for %s does not match %s:%d, possible man-in-the-middle attack: %w",
+				hostname, keyErr.Want[0].Filename, keyErr.Want[0].Line, err)
+		}
+		if !trustOnFirstUse {
+			return fmt.Errorf("host %s is not in %s: %w", hostname, path, err)
+		}
+
+		knownHostsMutex.Lock()
+		defer knownHostsMutex.Unlock()
+		f, ferr := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		if ferr != nil {
+			return ferr
+		}
+		defer f.Close()
+		log.Printf("Trusting host key of %s on first use: %s", hostname, ssh.FingerprintSHA256(key))
+		_, ferr = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return ferr
+	}, nil
 }
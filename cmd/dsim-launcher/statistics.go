@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// collectStatistics gathers the statistics of the finished engines of a run
+// and aggregates them
+func collectStatistics(clog *clock.ClockLogger, run dsim.RunId, simulationNodes []Node) (dsim.RunStatistics, error) {
+	var segments []dsim.SegmentStatistics
+	for _, result := range queryNodesStatus(clog, simulationNodes, run, probeTimeout) {
+		if result.err != nil {
+			return dsim.RunStatistics{}, fmt.Errorf("status of %v: %w", result.node.Name, result.err)
+		}
+		for _, runStatus := range result.status.Runs {
+			for _, engine := range runStatus.Engines {
+				if engine.Statistics == nil {
+					return dsim.RunStatistics{}, fmt.Errorf("segment %s of %v has no statistics", engine.Segment, result.node.Name)
+				}
+				segments = append(segments, *engine.Statistics)
+			}
+		}
+	}
+	return dsim.AggregateStatistics(run, segments), nil
+}
+
+// reportStatistics prints the statistics of a run and writes them to the
+// results directory, with the batch means estimates when the spec asks for
+// batches
+func reportStatistics(clog *clock.ClockLogger, spec *RunSpec, stats dsim.RunStatistics) {
+	stats.Print(os.Stdout)
+	writeResult(clog, spec.ResultsDir, fmt.Sprintf("%s-statistics.json", stats.Run), "Statistics", stats)
+	if spec.Batches > 1 {
+		estimates := dsim.EstimateBatchMeans(stats, spec.Confidence)
+		estimates.Print(os.Stdout)
+		writeResult(clog, spec.ResultsDir, fmt.Sprintf("%s-batchmeans.json", stats.Run), "Batch means estimates", estimates)
+	}
+}
+
+// writeResult writes a result of the launcher as JSON to the results
+// directory, what names it in the messages
+func writeResult(clog *clock.ClockLogger, resultsDir, name, what string, result any) {
+	filename := filepath.Join(resultsDir, name)
+	if err := writeJSON(filename, result); err != nil {
+		clog.LogErrorf("Write %s failed: %s", strings.ToLower(what), err)
+		return
+	}
+	fmt.Printf("%s written to %s\n", what, filename)
+}
+
+// writeJSON writes v to a file as indented JSON
+func writeJSON(filename string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
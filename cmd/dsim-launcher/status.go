@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+type nodeStatusResult struct {
+	node   Node
+	status dsim.NodeStatus
+	err    error
+}
+
+// statusCommand queries every node in the node file and prints their status
+func statusCommand(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	var nodeFile string
+	flags.StringVar(&nodeFile, "nodeFile", "simulation-nodes.json", "The simulation nodes list")
+	var timeout time.Duration
+	flags.DurationVar(&timeout, "timeout", 2*time.Second, "The status request timeout for every node")
+	var run string
+	flags.StringVar(&run, "run", "", "Report only this run")
+	flags.Parse(args)
+
+	// Status queries must not truncate the launcher log of a running simulation
+	clog := clock.NewClockLog("dsl-status", clock.ClockLogConfig{
+		Priority: clock.ERROR,
+	})
+
+	nodeList, err := loadNodesFromFile(nodeFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	results := queryNodesStatus(clog, nodeList, dsim.RunId(run), timeout)
+	printNodesStatus(os.Stdout, results)
+
+	for _, r := range results {
+		if r.err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+func queryNodesStatus(clog *clock.ClockLogger, nodeList []Node, run dsim.RunId, timeout time.Duration) []nodeStatusResult {
+	results := make([]nodeStatusResult, len(nodeList))
+	var wg sync.WaitGroup
+	for i, node := range nodeList {
+		wg.Add(1)
+		go func(i int, node Node) {
+			defer wg.Done()
+			status, err := queryNodeStatus(clog, node, run, timeout)
+			results[i] = nodeStatusResult{node: node, status: status, err: err}
+		}(i, node)
+	}
+	wg.Wait()
+	return results
+}
+
+// queryNodeStatus requests the status of a node, restricted to run if not empty
+func queryNodeStatus(clog *clock.ClockLogger, node Node, run dsim.RunId, timeout time.Duration) (dsim.NodeStatus, error) {
+	address := net.JoinHostPort(node.Address, node.Port)
+	cc := clog.LogDebugf("Send status request to %s", address)
+	response, err := communicator.SendReceiveTCPTimeout(address, dsim.StatusRequest{
+		Request: communicator.Request{ClockPayload: clog.Payload(cc)},
+		Run:     run,
+	}, timeout)
+	if err != nil {
+		return dsim.NodeStatus{}, err
+	}
+	clog.ObserveHLC(response)
+	switch mt := response.(type) {
+	case dsim.StatusResponse:
+		if mt.Error != nil {
+			return dsim.NodeStatus{}, mt.Error
+		}
+		return mt.Status, nil
+	case communicator.Response:
+		if mt.Error != nil {
+			return dsim.NodeStatus{}, mt.Error
+		}
+		return dsim.NodeStatus{}, fmt.Errorf("received unexpected response: %+v", mt)
+	default:
+		return dsim.NodeStatus{}, fmt.Errorf("received unknown response: %+v", mt)
+	}
+}
+
+func runState(status dsim.RunStatus) string {
+	switch {
+	case status.AbortReason != dsim.AbortNone:
+		return "aborted: " + status.AbortReason.String()
+	case status.Finished:
+		return "finished"
+	}
+	for _, engine := range status.Engines {
+		if engine.Running {
+			return "running"
+		}
+	}
+	return "prepared"
+}
+
+func printNodesStatus(out *os.File, results []nodeStatusResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tADDRESS\tRUN\tSTATE\tSEGMENTS\tEV SENT/RECV\tNULL SENT/RECV\tLOCAL\tOUT QUEUE")
+	for _, r := range results {
+		address := net.JoinHostPort(r.node.Address, r.node.Port)
+		switch {
+		case r.err != nil:
+			fmt.Fprintf(w, "%s\t%s\t-\tunreachable\t-\t-\t-\t-\t-\n", r.node.Name, address)
+			continue
+		case len(r.status.Runs) == 0:
+			fmt.Fprintf(w, "%s\t%s\t-\tidle\t-\t-\t-\t-\t-\n", r.status.Pid, address)
+			continue
+		}
+		for _, s := range r.status.Runs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d/%d\t%d/%d\t%d\t%d\n",
+				r.status.Pid, address, s.Run, runState(s), len(s.Engines),
+				s.EventsSent, s.EventsReceived, s.NullMessagesSent, s.NullMessagesReceived,
+				s.LocalMessages, s.OutgoingQueue)
+		}
+	}
+	w.Flush()
+
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(out, "%s: %s\n", r.node.Name, r.err)
+		}
+	}
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tRUN\tSEGMENT\tCLOCK\tEND\tEVENTS\tEVENT LIST\tEXT LIST\tBLOCKED ON")
+	for _, r := range results {
+		for _, s := range r.status.Runs {
+			for _, e := range s.Engines {
+				blockedOn := e.BlockedOn
+				if blockedOn == "" {
+					blockedOn = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%v\t%d\t%d\t%d\t%s\n",
+					r.status.Pid, s.Run, e.Segment, e.Clock, e.End, e.EventsProcessed, e.EventList, e.ExternalEventList, blockedOn)
+			}
+		}
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tRUN\tSEGMENT\tWAITING ON\tSEGMENT CLOCK\tQUEUED EVENTS")
+	for _, r := range results {
+		for _, s := range r.status.Runs {
+			for _, e := range s.Engines {
+				segments := e.Segments
+				sort.Slice(segments, func(i, j int) bool { return segments[i].Name < segments[j].Name })
+				for _, seg := range segments {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%d\n", r.status.Pid, s.Run, e.Segment, seg.Name, seg.Clock, seg.QueuedEvents)
+				}
+			}
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// Run spec parameters a sweep can vary besides the transition fields
+const (
+	SweepPeriod    = "period"
+	SweepLookahead = "lookahead"
+	SweepWarmUp    = "warmUp"
+	SweepSeed      = "seed"
+)
+
+// transitionSetters set the LEF fields of a transition a sweep can vary, by
+// their name in the model files. Only firing durations are parameters, the
+// constant and clock of a transition are its initial state.
+var transitionSetters = map[string]func(t *dsim.Transition, value float64){
+	"ii_duracion_disparo": func(t *dsim.Transition, value float64) { t.Duration = dsim.Clock(value) },
+}
+
+// transitionField is the value of a LEF field of some transitions
+type transitionField struct {
+	name        string
+	transitions []dsim.TransitionId
+	value       float64
+}
+
+// setTransitionFields sets the transition fields of the spec on the subnets
+// of its model, every transition when the field names none
+func (spec *RunSpec) setTransitionFields(subnets []Subnet) error {
+	for _, field := range spec.transitionFields {
+		set := transitionSetters[field.name]
+		if len(field.transitions) == 0 {
+			for _, subnet := range subnets {
+				for _, t := range subnet.Lefs.Network {
+					set(t, field.value)
+				}
+			}
+			continue
+		}
+		for _, id := range field.transitions {
+			found := false
+			for _, subnet := range subnets {
+				if t, ok := subnet.Lefs.Network[id]; ok {
+					set(t, field.value)
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("transition with global id %d of field %s is not in the model", id, field.name)
+			}
+		}
+	}
+	return nil
+}
+
+// SweepParameter is a parameter of a sweep and the values it takes
+type SweepParameter struct {
+	// Name is period, lookahead, warmUp, seed or the transition field
+	// ii_duracion_disparo
+	Name string `json:"name"`
+	// Transitions are the global ids (ii_idglobal) of the transitions whose
+	// field is varied, every transition when empty. Model files do not name
+	// transitions.
+	Transitions []dsim.TransitionId `json:"transitions,omitempty"`
+	Values      []float64           `json:"values"`
+}
+
+// Label names the parameter in the results table
+func (p SweepParameter) Label() string {
+	if len(p.Transitions) == 0 {
+		return p.Name
+	}
+	ids := make([]string, len(p.Transitions))
+	for i, id := range p.Transitions {
+		ids[i] = strconv.Itoa(int(id))
+	}
+	return fmt.Sprintf("%s[%s]", p.Name, strings.Join(ids, ","))
+}
+
+// Sweep is a grid of parameter values. Every point of the grid is run once
+// with the spec and its own run id, logs and results directories, so specs
+// with replications are rejected.
+type Sweep struct {
+	// Spec is the run spec file, relative to the sweep file
+	Spec       string           `json:"spec"`
+	Parameters []SweepParameter `json:"parameters"`
+}
+
+// loadSweep reads a sweep file
+func loadSweep(path string) (*Sweep, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sweep := &Sweep{}
+	if err := json.Unmarshal(data, sweep); err != nil {
+		return nil, fmt.Errorf("invalid sweep %s: %w", path, err)
+	}
+	if sweep.Spec == "" {
+		return nil, fmt.Errorf("sweep %s has no run spec", path)
+	}
+	if !filepath.IsAbs(sweep.Spec) {
+		sweep.Spec = filepath.Join(filepath.Dir(path), sweep.Spec)
+	}
+	return sweep, sweep.validate()
+}
+
+func (sweep *Sweep) validate() error {
+	if len(sweep.Parameters) == 0 {
+		return errors.New("sweep has no parameters")
+	}
+	for _, p := range sweep.Parameters {
+		switch p.Name {
+		case SweepPeriod, SweepLookahead, SweepWarmUp, SweepSeed:
+			if len(p.Transitions) > 0 {
+				return fmt.Errorf("sweep parameter %s takes no transitions", p.Name)
+			}
+		default:
+			if _, ok := transitionSetters[p.Name]; !ok {
+				return fmt.Errorf("unknown sweep parameter %q", p.Name)
+			}
+		}
+		if len(p.Values) == 0 {
+			return fmt.Errorf("sweep parameter %s has no values", p.Label())
+		}
+	}
+	return nil
+}
+
+// points expands the grid of the sweep, the last parameter varies fastest
+func (sweep *Sweep) points() [][]float64 {
+	points := [][]float64{nil}
+	for _, p := range sweep.Parameters {
+		expanded := make([][]float64, 0, len(points)*len(p.Values))
+		for _, point := range points {
+			for _, value := range p.Values {
+				expanded = append(expanded, append(append([]float64(nil), point...), value))
+			}
+		}
+		points = expanded
+	}
+	return points
+}
+
+// pointSpec returns the spec of point i of the sweep of a run
+func (sweep *Sweep) pointSpec(spec *RunSpec, run dsim.RunId, i int, point []float64) (*RunSpec, error) {
+	derived := spec.derive(fmt.Sprintf("%s-p%d", run, i+1))
+	derived.transitionFields = append([]transitionField(nil), spec.transitionFields...)
+	for j, p := range sweep.Parameters {
+		switch p.Name {
+		case SweepPeriod:
+			derived.End = point[j]
+		case SweepLookahead:
+			derived.Lookahead.Value = point[j]
+		case SweepWarmUp:
+			derived.WarmUp = point[j]
+		case SweepSeed:
+			derived.Seed = int64(point[j])
+		default:
+			derived.transitionFields = append(derived.transitionFields, transitionField{p.Name, p.Transitions, point[j]})
+		}
+	}
+	if err := derived.validate(); err != nil {
+		return nil, fmt.Errorf("sweep point %s: %w", sweep.describe(point), err)
+	}
+	return derived, nil
+}
+
+// describe returns the parameter values of a point
+func (sweep *Sweep) describe(point []float64) string {
+	values := make([]string, len(point))
+	for i, p := range sweep.Parameters {
+		values[i] = fmt.Sprintf("%s=%g", p.Label(), point[i])
+	}
+	return strings.Join(values, " ")
+}
+
+// sweepCommand runs every point of a sweep, Parallel of them at a time, and
+// collects their statistics in a single table
+func sweepCommand(args []string) int {
+	probe := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	probe.SetOutput(io.Discard)
+	bindRunFlags(probe, &RunSpec{})
+	if err := probe.Parse(args); err != nil || probe.NArg() != 1 {
+		log.Print("usage: dsim-launcher sweep [flags] sweep.json")
+		return 2
+	}
+	sweep, err := loadSweep(probe.Arg(0))
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	// The run flags apply over the spec of the sweep
+	flagArgs := append(args[:len(args)-1:len(args)-1], sweep.Spec)
+	spec, err := parseRunSpec("sweep", flagArgs, true, nil)
+	if err == nil {
+		err = spec.validateLaunch()
+	}
+	if err == nil && spec.Replications > 1 {
+		err = fmt.Errorf("sweep points are run once, %d replications cannot be run", spec.Replications)
+	}
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	run := spec.runId()
+	points := sweep.points()
+	specs := make([]*RunSpec, len(points))
+	for i, point := range points {
+		if specs[i], err = sweep.pointSpec(spec, run, i, point); err != nil {
+			log.Print(err)
+			return 2
+		}
+	}
+	// Check the transitions of the sweep before launching any run
+	subnets, _, err := loadLefs(spec.Model)
+	if err == nil {
+		err = specs[0].setTransitionFields(subnets)
+	}
+	if err == nil {
+		err = spec.validateParallel(len(specs))
+	}
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	fmt.Printf("Sweep %s: %d points, %d at a time\n", run, len(points), spec.Parallel)
+	clog := newLauncherLog(spec)
+	defer clog.Close()
+	clog.LogInfof("Starting sweep %s of %d points", run, len(points))
+	stats, errs := runSeries(specs, spec.Parallel)
+
+	printSweepResults(os.Stdout, sweep, points, stats, errs)
+	filename := filepath.Join(spec.ResultsDir, fmt.Sprintf("%s-sweep.csv", run))
+	if err := writeSweepResults(filename, sweep, points, stats); err != nil {
+		clog.LogErrorf("Write sweep results failed: %s", err)
+		return 1
+	}
+	fmt.Printf("Sweep results written to %s\n", filename)
+	for _, err := range errs {
+		if err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+// printSweepResults writes the run totals of every point of a sweep, then
+// the errors of the failed points
+func printSweepResults(w io.Writer, sweep *Sweep, points [][]float64, stats []*dsim.RunStatistics, errs []error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, p := range sweep.Parameters {
+		fmt.Fprintf(tw, "%s\t", strings.ToUpper(p.Label()))
+	}
+	fmt.Fprintln(tw, "RUN\tFIRED\tTHROUGHPUT\tINTER-FIRING MEAN")
+	for i, point := range points {
+		for _, value := range point {
+			fmt.Fprintf(tw, "%g\t", value)
+		}
+		switch {
+		case errs[i] != nil:
+			fmt.Fprintln(tw, "-\tfailed\t\t")
+		default:
+			fmt.Fprintf(tw, "%s\t%d\t%.4f\t%.4f\n", stats[i].Run, stats[i].Fired, stats[i].Throughput, stats[i].InterFiring.Mean)
+		}
+	}
+	tw.Flush()
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(w, "Point %s failed: %s\n", sweep.describe(points[i]), err)
+		}
+	}
+}
+
+// writeSweepResults writes the statistics of every transition of every
+// point of a sweep as CSV, keyed by the parameter values
+func writeSweepResults(filename string, sweep *Sweep, points [][]float64, stats []*dsim.RunStatistics) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	var header []string
+	for _, p := range sweep.Parameters {
+		header = append(header, p.Label())
+	}
+	header = append(header, "run", "transition", "segment", "fired", "throughput", "mean_value", "utilization", "inter_firing_mean", "inter_firing_stddev")
+	w.Write(header)
+	float := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for i, point := range points {
+		if stats[i] == nil {
+			continue
+		}
+		var key []string
+		for _, value := range point {
+			key = append(key, float(value))
+		}
+		for _, ts := range stats[i].Transitions {
+			w.Write(append(key[:len(key):len(key)], string(stats[i].Run), strconv.Itoa(int(ts.Transition)), ts.Segment,
+				strconv.FormatUint(ts.Fired, 10), float(ts.Throughput), float(ts.MeanValue), float(ts.Utilization),
+				float(ts.InterFiring.Mean), float(ts.InterFiring.StdDev())))
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+func TestSweepPoints(t *testing.T) {
+	sweep := &Sweep{Parameters: []SweepParameter{
+		{Name: SweepPeriod, Values: []float64{10, 20}},
+		{Name: SweepSeed, Values: []float64{1, 2, 3}},
+	}}
+	want := [][]float64{{10, 1}, {10, 2}, {10, 3}, {20, 1}, {20, 2}, {20, 3}}
+	if points := sweep.points(); !reflect.DeepEqual(points, want) {
+		t.Fatalf("Points %v, want %v", points, want)
+	}
+}
+
+func TestSweepParameterLabel(t *testing.T) {
+	tests := []struct {
+		parameter SweepParameter
+		want      string
+	}{
+		{SweepParameter{Name: SweepPeriod}, "period"},
+		{SweepParameter{Name: "ii_duracion_disparo"}, "ii_duracion_disparo"},
+		{SweepParameter{Name: "ii_duracion_disparo", Transitions: []dsim.TransitionId{3}}, "ii_duracion_disparo[3]"},
+		{SweepParameter{Name: "ii_duracion_disparo", Transitions: []dsim.TransitionId{0, 2}}, "ii_duracion_disparo[0,2]"},
+	}
+	for _, test := range tests {
+		if label := test.parameter.Label(); label != test.want {
+			t.Errorf("Label of %+v = %q, want %q", test.parameter, label, test.want)
+		}
+	}
+}
+
+func TestSetTransitionFields(t *testing.T) {
+	subnets := func() []Subnet {
+		return []Subnet{
+			{Name: "subred0", Lefs: dsim.Lefs{Network: dsim.TransitionMap{
+				0: &dsim.Transition{Id: 0, Duration: 1},
+				1: &dsim.Transition{Id: 1, Duration: 1},
+			}}},
+			{Name: "subred1", Lefs: dsim.Lefs{Network: dsim.TransitionMap{
+				2: &dsim.Transition{Id: 2, Duration: 1},
+			}}},
+		}
+	}
+	durations := func(subnets []Subnet) map[dsim.TransitionId]dsim.Clock {
+		d := make(map[dsim.TransitionId]dsim.Clock)
+		for _, subnet := range subnets {
+			for id, t := range subnet.Lefs.Network {
+				d[id] = t.Duration
+			}
+		}
+		return d
+	}
+	tests := []struct {
+		name   string
+		fields []transitionField
+		want   map[dsim.TransitionId]dsim.Clock
+		fails  bool
+	}{
+		{"none", nil, map[dsim.TransitionId]dsim.Clock{0: 1, 1: 1, 2: 1}, false},
+		{"every transition", []transitionField{{"ii_duracion_disparo", nil, 5}},
+			map[dsim.TransitionId]dsim.Clock{0: 5, 1: 5, 2: 5}, false},
+		{"listed transitions", []transitionField{{"ii_duracion_disparo", []dsim.TransitionId{1, 2}, 3}},
+			map[dsim.TransitionId]dsim.Clock{0: 1, 1: 3, 2: 3}, false},
+		{"missing transition", []transitionField{{"ii_duracion_disparo", []dsim.TransitionId{7}, 3}}, nil, true},
+	}
+	for _, test := range tests {
+		spec := &RunSpec{transitionFields: test.fields}
+		s := subnets()
+		err := spec.setTransitionFields(s)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if d := durations(s); !reflect.DeepEqual(d, test.want) {
+			t.Errorf("%s: durations %v, want %v", test.name, d, test.want)
+		}
+	}
+}
+
+func TestSweepValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters []SweepParameter
+		valid      bool
+	}{
+		{"no parameters", nil, false},
+		{"run parameter", []SweepParameter{{Name: SweepWarmUp, Values: []float64{0, 5}}}, true},
+		{"transition field", []SweepParameter{{Name: "ii_duracion_disparo", Transitions: []dsim.TransitionId{1}, Values: []float64{2}}}, true},
+		{"run parameter with transitions", []SweepParameter{{Name: SweepLookahead, Transitions: []dsim.TransitionId{1}, Values: []float64{1}}}, false},
+		{"initial state", []SweepParameter{{Name: "ii_valor", Values: []float64{1}}}, false},
+		{"unknown parameter", []SweepParameter{{Name: "speed", Values: []float64{1}}}, false},
+		{"no values", []SweepParameter{{Name: SweepPeriod}}, false},
+	}
+	for _, test := range tests {
+		err := (&Sweep{Parameters: test.parameters}).validate()
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%s: validate() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+const (
+	// probeInterval is the wait between two termination detection waves
+	probeInterval = 200 * time.Millisecond
+	// probeTimeout bounds every termination probe and terminate request
+	probeTimeout = 5 * time.Second
+)
+
+// detectTermination probes the nodes in waves until the four counter method
+// proves every node finished and every message has been delivered. It gives
+// up once stop is closed.
+func detectTermination(clog *clock.ClockLogger, run dsim.RunId, simulationNodes []Node, stop <-chan struct{}) error {
+	var previous *dsim.TerminationWave
+	for wave := 1; ; wave++ {
+		current := dsim.NewTerminationWave()
+		for _, v := range simulationNodes {
+			probe, err := probeNode(clog, run, v, wave)
+			if err != nil {
+				return err
+			}
+			current.Add(v.Name, probe)
+		}
+		if current.Terminated(previous) {
+			clog.LogInfof("Termination detected on wave %d: %d messages delivered", wave, current.Received)
+			return nil
+		}
+		previous = current
+		select {
+		case <-stop:
+			return fmt.Errorf("run %s stopped on wave %d", run, wave)
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+func probeNode(clog *clock.ClockLogger, run dsim.RunId, node Node, wave int) (dsim.TerminationProbeResponse, error) {
+	address := net.JoinHostPort(node.Address, node.Port)
+	cc := clog.LogDebugf("Send termination probe %d to %s", wave, address)
+	response, err := communicator.SendReceiveTCPTimeout(address, dsim.TerminationProbeRequest{
+		Request: communicator.Request{ClockPayload: clog.Payload(cc)},
+		Run:     run,
+		Wave:    wave,
+	}, probeTimeout)
+	if err != nil {
+		return dsim.TerminationProbeResponse{}, fmt.Errorf("termination probe to %v: %w", node.Name, err)
+	}
+	clog.ObserveHLC(response)
+
+	switch mt := response.(type) {
+	case dsim.TerminationProbeResponse:
+		if mt.Error != nil {
+			return mt, fmt.Errorf("received unsucessful response from %v: %w", node.Name, mt.Error)
+		}
+		return mt, nil
+	case communicator.Response:
+		if mt.Error != nil {
+			return dsim.TerminationProbeResponse{}, fmt.Errorf("received error response from %v: %w", node.Name, mt.Error)
+		}
+		return dsim.TerminationProbeResponse{}, fmt.Errorf("received unexpected response from %v: %+v", node.Name, mt)
+	default:
+		return dsim.TerminationProbeResponse{}, fmt.Errorf("received unknown response from %v: %+v", node.Name, mt)
+	}
+}
+
+// terminateSimulation lets every node exit once termination has been detected
+func terminateSimulation(clog *clock.ClockLogger, run dsim.RunId, simulationNodes []Node) error {
+	for _, v := range simulationNodes {
+		address := net.JoinHostPort(v.Address, v.Port)
+		cc := clog.LogInfof("Send terminate request to %s", address)
+		response, err := communicator.SendReceiveTCPTimeout(address, dsim.TerminateRequest{
+			Request: communicator.Request{ClockPayload: clog.Payload(cc)},
+			Run:     run,
+		}, probeTimeout)
+		if err != nil {
+			return fmt.Errorf("terminate %v: %w", v.Name, err)
+		}
+		clog.ObserveHLC(response)
+
+		switch mt := response.(type) {
+		case dsim.TerminateResponse:
+			if mt.Error != nil {
+				return fmt.Errorf("received unsucessful response from %v: %w", v.Name, mt.Error)
+			}
+		case communicator.Response:
+			if mt.Error != nil {
+				return fmt.Errorf("received error response from %v: %w", v.Name, mt.Error)
+			}
+			return fmt.Errorf("received unexpected response from %v: %+v", v.Name, mt)
+		default:
+			return fmt.Errorf("received unknown response from %v: %+v", v.Name, mt)
+		}
+	}
+	return nil
+}
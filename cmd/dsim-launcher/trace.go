@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
+)
+
+// traceFileExt is the extension of the node traces written with -trace
+const traceFileExt = ".trace.json"
+
+// traceCommand merges the node traces of a run, or builds them from the JSON
+// logs of the run, into a single Chrome trace
+func traceCommand(args []string) int {
+	spec, err := defaultRunSpec()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	flags := flag.NewFlagSet("trace", flag.ExitOnError)
+	flags.StringVar(&spec.LogsDir, "logsDir", spec.LogsDir, "The directory of the node traces or JSON logs")
+	var output string
+	flags.StringVar(&output, "o", "", "The merged Chrome trace, trace.json in the logs directory when empty")
+	flags.Parse(args)
+	if output == "" {
+		output = filepath.Join(spec.LogsDir, "trace.json")
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		if files, err = runTraceFiles(spec.LogsDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	var events []dsim.TraceEvent
+	for _, file := range files {
+		fileEvents, err := readTraceFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		events = append(events, fileEvents...)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	if err := dsim.WriteTrace(f, events); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Merged %d trace events of %d files into %s\n", len(events), len(files), output)
+	return 0
+}
+
+// runTraceFiles returns the node traces of the logs directory or, without
+// traces, the JSON logs
+func runTraceFiles(logsDir string) ([]string, error) {
+	traces, err := filepath.Glob(filepath.Join(logsDir, "*"+traceFileExt))
+	if err != nil || len(traces) > 0 {
+		return traces, err
+	}
+	logs, err := filepath.Glob(filepath.Join(logsDir, "*"+jsonLogFileExt))
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("no traces nor JSON logs found in %s", logsDir)
+	}
+	return logs, nil
+}
+
+// readTraceFile reads the events of a node trace, or the trace events of the
+// activities logged in a JSON log
+func readTraceFile(file string) ([]dsim.TraceEvent, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if filepath.Ext(file) != jsonLogFileExt {
+		events, err := dsim.ReadTrace(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return events, nil
+	}
+
+	records, err := clock.ReadJSONLog(f, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	var (
+		events []dsim.TraceEvent
+		tracks = make(map[string]bool)
+	)
+	for _, record := range records {
+		activity, ok := dsim.ActivityFromRecord(record)
+		if !ok {
+			continue
+		}
+		if !tracks[activity.Segment] {
+			tracks[activity.Segment] = true
+			events = append(events, dsim.TrackEvents(activity.Node, activity.Segment)...)
+		}
+		events = append(events, dsim.ActivityEvents(activity)...)
+	}
+	return events, nil
+}
@@ -15,6 +15,7 @@ import (
 	"syscall"
 
 	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
 	"github.com/mursisoy/distributed-petri-net-simulator/internal/dsim"
 )
 
@@ -36,18 +37,64 @@ func main() {
 	var lookahead float64
 	flag.Float64Var(&lookahead, "lookahead", 1, "The lookahead")
 
+	var shivizFile string
+	flag.StringVar(&shivizFile, "shivizfile", "", "Write a ShiViz stream of the log to this file")
+
+	var jsonLogFile string
+	flag.StringVar(&jsonLogFile, "jsonlogfile", "", "Write a JSON Lines record of every logged event to this file")
+
+	var hlcStamps bool
+	flag.BoolVar(&hlcStamps, "hlc", false, "Stamp the logged events with hybrid logical clocks")
+
+	var noVectorClocks bool
+	flag.BoolVar(&noVectorClocks, "novectorclocks", false, "Do not keep nor send vector clocks, for performance runs")
+
+	var logLevel string
+	flag.StringVar(&logLevel, "loglevel", "DEBUG", "The minimum priority of logged messages")
+
+	var daemon bool
+	flag.BoolVar(&daemon, "daemon", false, "Keep serving simulation runs until interrupted instead of exiting after the first one")
+
+	var traceFile string
+	flag.StringVar(&traceFile, "tracefile", "", "Write a Chrome trace of the activity of the hosted segments to this file")
+
+	var metricsAddress string
+	flag.StringVar(&metricsAddress, "metrics", "", "Serve Prometheus metrics at /metrics on this address, none when empty")
+
+	var registerAddress string
+	flag.StringVar(&registerAddress, "register", "", "The launcher address to announce the node id, endpoint and capabilities to once listening")
+
+	retryPolicy := communicator.DefaultRetryPolicy
+	flag.IntVar(&retryPolicy.Attempts, "sendAttempts", retryPolicy.Attempts, "Attempts to deliver a message to another node")
+	flag.DurationVar(&retryPolicy.Timeout, "sendTimeout", retryPolicy.Timeout, "Deadline of every attempt to deliver a message")
+	flag.DurationVar(&retryPolicy.Backoff, "sendBackoff", retryPolicy.Backoff, "Initial wait between delivery attempts")
+
 	flag.Parse()
 
 	if resultPath == "" {
 		log.Fatalf("resultpath argument is mandatory")
 	}
 
+	priority, err := clock.ParseLogPriority(logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	nodeConfig := dsim.SimulationNodeConfig{
-		ListenAddress: listenAddress,
+		ListenAddress:   listenAddress,
+		RetryPolicy:     retryPolicy,
+		Daemon:          daemon,
+		RegisterAddress: registerAddress,
+		MetricsAddress:  metricsAddress,
+		TraceFilename:   traceFile,
 		ClockLogConfig: clock.ClockLogConfig{
-			Priority:    clock.DEBUG,
-			FileOutput:  true,
-			LogFilename: logfile,
+			Priority:       priority,
+			FileOutput:     true,
+			LogFilename:    logfile,
+			ShiVizFilename: shivizFile,
+			JSONFilename:   jsonLogFile,
+			DisableClocks:  noVectorClocks,
+			HLCStamps:      hlcStamps,
 		},
 		SimulationEngineConfig: dsim.SimulationEngineConfig{
 			Lookahead:  dsim.Clock(lookahead),
@@ -66,22 +113,18 @@ func main() {
 
 	// Goroutine to catch shutdown signals
 	go func() {
-		select {
-		case <-sigCh:
-			fmt.Println("main: interrupt received. cancelling context.")
-		case <-node.Done():
-			fmt.Printf("main: node done")
-			os.Exit(0)
-		}
+		<-sigCh
+		fmt.Println("main: interrupt received. cancelling context.")
 		cancel()
 	}()
 
 	if _, err := node.Start(ctx); err != nil {
+		log.Printf("Failed to start node: %v", err)
 		cancel()
+		os.Exit(dsim.AbortNodeFailure.ExitCode())
 	}
-	<-ctx.Done()
-	if ctx.Err() != nil {
-		log.Printf("Exited: %v", ctx.Err())
-		os.Exit(1)
-	}
+	<-node.Done()
+	reason := node.AbortReason()
+	fmt.Printf("main: node done: %s\n", reason)
+	os.Exit(reason.ExitCode())
 }
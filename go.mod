@@ -3,6 +3,11 @@ module github.com/mursisoy/distributed-petri-net-simulator
 go 1.19
 
 require (
-	golang.org/x/crypto v0.18.0 // indirect
+	github.com/pkg/sftp v1.13.6
+	golang.org/x/crypto v0.18.0
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
 	golang.org/x/sys v0.16.0 // indirect
 )
@@ -0,0 +1,81 @@
+package clock
+
+import "fmt"
+
+// CausalityViolation is a logged event whose vector clock contradicts the
+// events it depends on
+type CausalityViolation struct {
+	Event LogEntry
+	// Cause is the event Event should follow, the previous event of the same
+	// process or the event of another process known by Event
+	Cause  LogEntry
+	Reason string
+}
+
+func (v CausalityViolation) String() string {
+	return fmt.Sprintf("%s event %d (%s: %q) %s: %s %s does not follow %s %s",
+		v.Event.Pid, v.Event.Clock[v.Event.Pid], v.Event.Source, v.Event.Message, v.Reason,
+		v.Event.Pid, v.Event.Clock, v.Cause.Pid, v.Cause.Clock)
+}
+
+// CheckCausality checks the events of several process logs, each one in the
+// order it was written by its process. Every event must follow the previous
+// event of its process, ticking its own entry once, and every event of another
+// process it knows of, the event of q with tick k when its clock holds k for q.
+func CheckCausality(entries []LogEntry) []CausalityViolation {
+	var violations []CausalityViolation
+
+	// Events of every process indexed by their own tick
+	events := make(map[string]map[uint64]LogEntry)
+	last := make(map[string]LogEntry)
+	for _, e := range entries {
+		if previous, ok := last[e.Pid]; ok {
+			switch {
+			case !previous.Clock.HappenedBefore(e.Clock):
+				violations = append(violations, CausalityViolation{e, previous, relation(e.Clock.Compare(previous.Clock)) + " the previous event"})
+			case e.Clock[e.Pid] != previous.Clock[e.Pid]+1:
+				violations = append(violations, CausalityViolation{e, previous, fmt.Sprintf("skips %d ticks", e.Clock[e.Pid]-previous.Clock[e.Pid]-1)})
+			}
+		}
+		last[e.Pid] = e
+		if events[e.Pid] == nil {
+			events[e.Pid] = make(map[uint64]LogEntry)
+		}
+		events[e.Pid][e.Clock[e.Pid]] = e
+	}
+
+	for _, e := range entries {
+		for pid, ticks := range e.Clock {
+			if pid == e.Pid || ticks == 0 {
+				continue
+			}
+			// Processes without log cannot be checked
+			known, ok := events[pid]
+			if !ok {
+				continue
+			}
+			cause, ok := known[ticks]
+			switch {
+			case !ok:
+				violations = append(violations, CausalityViolation{e, LogEntry{Pid: pid, Clock: ClockMap{pid: ticks}}, "knows an event missing from the log"})
+			case !cause.Clock.HappenedBefore(e.Clock):
+				violations = append(violations, CausalityViolation{e, cause, relation(e.Clock.Compare(cause.Clock)) + " the event it knows of"})
+			}
+		}
+	}
+	return violations
+}
+
+// relation describes how an event is ordered with respect to its cause
+func relation(o Ordering) string {
+	switch o {
+	case Before:
+		return "happened before"
+	case Equal:
+		return "has the clock of"
+	case Concurrent:
+		return "is concurrent with"
+	default:
+		return "happened after"
+	}
+}
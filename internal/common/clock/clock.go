@@ -21,6 +21,21 @@ type Clock struct {
 type ClockPayload struct {
 	Pid   string
 	Clock ClockMap
+	// Compact replaces Clock on links encoding their clocks with a
+	// ClockEncoder
+	Compact *CompactClock
+	// HLC is the hybrid logical clock of the message, zero when missing
+	HLC HLC
+}
+
+// Stamped is a message carrying a ClockPayload
+type Stamped interface {
+	Stamp() HLC
+}
+
+// Stamp returns the hybrid logical clock of the message
+func (p ClockPayload) Stamp() HLC {
+	return p.HLC
 }
 
 // NewClock returns a new, empty vector clock
@@ -120,3 +135,74 @@ func (c ClockMap) String() string {
 	buffer.WriteString("}")
 	return buffer.String()
 }
+
+// Ordering is the causal relation between two vector clocks
+type Ordering int
+
+const (
+	// Concurrent clocks are not causally related
+	Concurrent Ordering = iota
+	// Before means the clock happened before the other
+	Before
+	// After means the other clock happened before the clock
+	After
+	// Equal clocks belong to the same event
+	Equal
+)
+
+var orderingLookup = [...]string{
+	Concurrent: "concurrent",
+	Before:     "before",
+	After:      "after",
+	Equal:      "equal",
+}
+
+func (o Ordering) String() string {
+	if o >= 0 && int(o) < len(orderingLookup) {
+		return orderingLookup[o]
+	}
+	return fmt.Sprintf("Ordering(%d)", int(o))
+}
+
+// Compare returns the causal ordering of c with respect to other. Ids missing
+// from a clock count as zero.
+func (c ClockMap) Compare(other ClockMap) Ordering {
+	less, greater := false, false
+	for id, ticks := range c {
+		if ticks < other[id] {
+			less = true
+		} else if ticks > other[id] {
+			greater = true
+		}
+	}
+	for id, ticks := range other {
+		if _, ok := c[id]; !ok && ticks > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	default:
+		return Equal
+	}
+}
+
+// HappenedBefore reports whether c happened before other
+func (c ClockMap) HappenedBefore(other ClockMap) bool {
+	return c.Compare(other) == Before
+}
+
+// Concurrent reports whether neither c nor other happened before the other
+func (c ClockMap) Concurrent(other ClockMap) bool {
+	return c.Compare(other) == Concurrent
+}
+
+// Equal reports whether c and other hold the same ticks
+func (c ClockMap) Equal(other ClockMap) bool {
+	return c.Compare(other) == Equal
+}
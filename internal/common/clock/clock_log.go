@@ -5,6 +5,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
+	"sync"
 )
 
 // LogPriority controls the minimum priority of logging events which
@@ -30,24 +32,59 @@ var prefixLookup = [...]string{
 	FATAL:   "FATAL",
 }
 
+// ParseLogPriority returns the priority with the given name, case insensitive
+func ParseLogPriority(name string) (LogPriority, error) {
+	for priority, prefix := range prefixLookup {
+		if strings.EqualFold(prefix, name) {
+			return LogPriority(priority), nil
+		}
+	}
+	return DEBUG, fmt.Errorf("unknown log priority %q", name)
+}
+
+func (p LogPriority) String() string {
+	if p >= 0 && int(p) < len(prefixLookup) {
+		return prefixLookup[p]
+	}
+	return fmt.Sprintf("LogPriority(%d)", int(p))
+}
+
 // LogConfig controls the logging parameters of Log and is taken as
 // input to Log initialization. See defaults in GetDefaultConfig.
 type ClockLogConfig struct {
 
-	// EncodingStrategy for customizable interoperability
+	// EncodingStrategy encodes the records of the JSON sink, json.Marshal
+	// when nil
 	EncodingStrategy func(interface{}) ([]byte, error)
-	// DecodingStrategy for customizable interoperability
+	// DecodingStrategy decodes the records read by ReadJSONLog, json.Unmarshal
+	// when nil
 	DecodingStrategy func([]byte, interface{}) error
 	// Priority determines the minimum priority event to log
 	Priority LogPriority
 
 	LogFilename string
 	FileOutput  bool
+	// ShiVizFilename receives a ShiViz stream of the logged events, with
+	// multi-line messages escaped, when not empty
+	ShiVizFilename string
+	// JSONFilename receives a JSON Lines record of every logged event when
+	// not empty
+	JSONFilename string
+	// DisableClocks stops keeping the vector clock for performance runs:
+	// events are logged with an empty clock and no clock is returned to be
+	// sent with messages
+	DisableClocks bool
+	// HLCStamps stamps every logged event with the hybrid logical clock, so
+	// merged logs of hosts with skewed clocks sort consistently
+	HLCStamps bool
 }
 
 type ClockLogger struct {
 	pid string
 
+	// mu keeps the events in the logs in the order of their clock ticks
+	mu sync.Mutex
+
 	// Priority level at which all events are logged
 	priority LogPriority
 
@@ -59,7 +96,20 @@ type ClockLogger struct {
 
 	fileOutput bool
 
-	clock *Clock
+	// shiviz writes the ShiViz stream, nil without ShiVizFilename
+	shiviz     *log.Logger
+	shivizFile *os.File
+
+	// json writes the JSON Lines sink, nil without JSONFilename
+	json     *jsonSink
+	jsonFile *os.File
+
+	clock         *Clock
+	disableClocks bool
+
+	// hlc stamps the messages sent, and the logged events with hlcStamps
+	hlc       *HybridClock
+	hlcStamps bool
 }
 
 // InitGoVector returns a Log which generates a logs prefixed with
@@ -72,6 +122,9 @@ func NewClockLog(pid string, config ClockLogConfig) *ClockLogger {
 	clockLog.priority = config.Priority
 	clockLog.fileOutput = config.FileOutput
 	clockLog.clock = NewClock(pid)
+	clockLog.disableClocks = config.DisableClocks
+	clockLog.hlc = NewHybridClock(nil)
+	clockLog.hlcStamps = config.HLCStamps
 
 	//Starting File IO . If Log exists, Log Will be deleted and A New one will be created
 	var mw io.Writer
@@ -89,6 +142,24 @@ func NewClockLog(pid string, config ClockLogConfig) *ClockLogger {
 	}
 	clockLog.logger = log.New(mw, "", log.Lshortfile|log.Lmicroseconds)
 
+	if config.ShiVizFilename != "" {
+		shivizFile, err := os.Create(config.ShiVizFilename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clockLog.shivizFile = shivizFile
+		clockLog.shiviz = log.New(shivizFile, "", log.Lshortfile|log.Lmicroseconds)
+	}
+
+	if config.JSONFilename != "" {
+		jsonFile, err := os.Create(config.JSONFilename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clockLog.jsonFile = jsonFile
+		clockLog.json = newJSONSink(jsonFile, config.EncodingStrategy)
+	}
+
 	return clockLog
 }
 
@@ -96,12 +167,47 @@ func (cl *ClockLogger) GetPid() string {
 	return cl.pid
 }
 
+// Payload returns the clock payload of a message sent after the event
+// logged with clock cc, stamped with the hybrid logical clock
+func (cl *ClockLogger) Payload(cc ClockMap) ClockPayload {
+	return ClockPayload{Pid: cl.pid, Clock: cc, HLC: cl.hlc.Now()}
+}
+
+// HasJSONSink tells whether the events are recorded in a JSON Lines log
+func (cl *ClockLogger) HasJSONSink() bool {
+	return cl.json != nil
+}
+
+// ResponsePayload returns the clock payload of a response, which is not
+// logged as an event: it only carries the hybrid logical clock
+func (cl *ClockLogger) ResponsePayload() ClockPayload {
+	return ClockPayload{Pid: cl.pid, HLC: cl.hlc.Now()}
+}
+
+// ObserveHLC merges the hybrid logical clock of a received message, before
+// its receive is logged. Messages without ClockPayload are ignored.
+func (cl *ClockLogger) ObserveHLC(message any) {
+	if stamped, ok := message.(Stamped); ok {
+		cl.hlc.Update(stamped.Stamp())
+	}
+}
+
 func (cl *ClockLogger) Close() {
 	if cl.fileOutput {
 		if err := cl.logfile.Close(); err != nil {
 			log.Printf("Failed to close log file: %v", err)
 		}
 	}
+	if cl.shivizFile != nil {
+		if err := cl.shivizFile.Close(); err != nil {
+			log.Printf("Failed to close ShiViz file: %v", err)
+		}
+	}
+	if cl.jsonFile != nil {
+		if err := cl.jsonFile.Close(); err != nil {
+			log.Printf("Failed to close JSON log file: %v", err)
+		}
+	}
 }
 
 // Logs a DEBUG message along with a processID and a vector clock
@@ -138,9 +244,11 @@ func (cl *ClockLogger) LogErrorf(format string, v ...any) ClockMap {
 func (cl *ClockLogger) LogMergef(level LogPriority, clock ClockMap, format string, v ...any) ClockMap {
 	var clockMap ClockMap
 	if level >= cl.priority {
-		clockMap = cl.clock.TickAndMerge(clock)
-		cl.logger.Output(3, fmt.Sprintf("[%s] - %s\n%s %s", prefixLookup[cl.priority], fmt.Sprintf(format, v...), cl.pid, clockMap))
-	} else {
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		clockMap = cl.tick(clock)
+		cl.output(3, level, clockMap, nil, fmt.Sprintf(format, v...))
+	} else if !cl.disableClocks {
 		clockMap = cl.clock.GetClock()
 	}
 	return clockMap
@@ -149,10 +257,65 @@ func (cl *ClockLogger) LogMergef(level LogPriority, clock ClockMap, format strin
 func (cl *ClockLogger) Logf(level LogPriority, format string, v ...any) ClockMap {
 	var clockMap ClockMap
 	if level >= cl.priority {
-		clockMap = cl.clock.Tick()
-		cl.logger.Output(3, fmt.Sprintf("[%s] - %s\n%s %s", prefixLookup[cl.priority], fmt.Sprintf(format, v...), cl.pid, clockMap))
-	} else {
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		clockMap = cl.tick(nil)
+		cl.output(3, level, clockMap, nil, fmt.Sprintf(format, v...))
+	} else if !cl.disableClocks {
 		clockMap = cl.clock.GetClock()
 	}
 	return clockMap
 }
+
+// LogFieldsf logs a message with typed fields, written as such by the JSON
+// sink. The clock received with a message is merged when not nil.
+func (cl *ClockLogger) LogFieldsf(level LogPriority, clock ClockMap, fields Fields, format string, v ...any) ClockMap {
+	var clockMap ClockMap
+	if level >= cl.priority {
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		clockMap = cl.tick(clock)
+		cl.output(2, level, clockMap, fields, fmt.Sprintf(format, v...))
+	} else if !cl.disableClocks {
+		clockMap = cl.clock.GetClock()
+	}
+	return clockMap
+}
+
+// tick advances the clock merging the clock received with a message when
+// not nil, returning nil when clocks are disabled
+func (cl *ClockLogger) tick(clock ClockMap) ClockMap {
+	switch {
+	case cl.disableClocks:
+		return nil
+	case clock == nil:
+		return cl.clock.Tick()
+	default:
+		return cl.clock.TickAndMerge(clock)
+	}
+}
+
+// output writes an event to the log and its ShiViz and JSON sinks. calldepth
+// is counted from the caller of output, as in log.Logger.Output.
+func (cl *ClockLogger) output(calldepth int, level LogPriority, clockMap ClockMap, fields Fields, message string) {
+	var stamp HLC
+	priority := "[" + prefixLookup[cl.priority] + "]"
+	if cl.hlcStamps {
+		stamp = cl.hlc.Now()
+		priority += " hlc=" + stamp.String()
+	}
+	cl.logger.Output(calldepth+1, fmt.Sprintf("%s - %s\n%s %s", priority, message, cl.pid, clockMap))
+	if cl.shiviz != nil {
+		cl.shiviz.Output(calldepth+1, fmt.Sprintf("%s - %s\n%s %s", priority, EscapeShiVizEvent(message), cl.pid, clockMap))
+	}
+	if cl.json != nil {
+		cl.json.write(calldepth+1, LogRecord{
+			Level:   prefixLookup[level],
+			Pid:     cl.pid,
+			Clock:   clockMap,
+			HLC:     stamp,
+			Message: message,
+			Fields:  fields,
+		})
+	}
+}
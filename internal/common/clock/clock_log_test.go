@@ -0,0 +1,24 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponsePayloadIsObserved(t *testing.T) {
+	wall := time.UnixMilli(1000)
+	node := NewClockLog("sn1", ClockLogConfig{Priority: ERROR})
+	node.hlc = NewHybridClock(func() time.Time { return wall.Add(time.Second) })
+	launcher := NewClockLog("dsl", ClockLogConfig{Priority: ERROR})
+	launcher.hlc = NewHybridClock(func() time.Time { return wall })
+
+	// Responses embed the payload as communicator.Response does
+	response := struct{ ClockPayload }{node.ResponsePayload()}
+	if response.HLC.IsZero() || response.Clock != nil {
+		t.Fatalf("Response payload %+v, want only a hybrid logical clock", response.ClockPayload)
+	}
+	launcher.ObserveHLC(response)
+	if next := launcher.hlc.Now(); next <= response.HLC {
+		t.Fatalf("Launcher clock %s not after response %s", next, response.HLC)
+	}
+}
@@ -3,6 +3,7 @@ package clock
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestBasicInit(t *testing.T) {
@@ -72,3 +73,105 @@ func TestAndMerge(t *testing.T) {
 		t.Fatalf("Merge not as expected")
 	}
 }
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b ClockMap
+		want Ordering
+	}{
+		{ClockMap{"a": 1}, ClockMap{"a": 1}, Equal},
+		{ClockMap{"a": 1}, ClockMap{"a": 1, "b": 0}, Equal},
+		{ClockMap{"a": 1}, ClockMap{"a": 2}, Before},
+		{ClockMap{"a": 1}, ClockMap{"a": 1, "b": 1}, Before},
+		{ClockMap{"a": 2, "b": 1}, ClockMap{"a": 1, "b": 1}, After},
+		{ClockMap{"a": 2}, ClockMap{"a": 1, "b": 1}, Concurrent},
+		{ClockMap{}, ClockMap{}, Equal},
+	}
+	for _, test := range tests {
+		if got := test.a.Compare(test.b); got != test.want {
+			t.Errorf("%v.Compare(%v) = %s, want %s", test.a, test.b, got, test.want)
+		}
+	}
+
+	a, b := ClockMap{"a": 1}, ClockMap{"a": 1, "b": 2}
+	if !a.HappenedBefore(b) || b.HappenedBefore(a) {
+		t.Fatalf("HappenedBefore not consistent with Compare")
+	}
+	if !(ClockMap{"a": 2}).Concurrent(b) || a.Concurrent(b) {
+		t.Fatalf("Concurrent not consistent with Compare")
+	}
+	if !a.Equal(ClockMap{"a": 1, "c": 0}) || a.Equal(b) {
+		t.Fatalf("Equal not consistent with Compare")
+	}
+}
+
+func TestCheckCausality(t *testing.T) {
+	send := LogEntry{Pid: "a", Clock: ClockMap{"a": 1}}
+	receive := LogEntry{Pid: "b", Clock: ClockMap{"a": 1, "b": 1}}
+	if violations := CheckCausality([]LogEntry{send, receive}); len(violations) != 0 {
+		t.Fatalf("Violations in a valid log: %v", violations)
+	}
+
+	// b knows of the second event of a before it has been sent
+	entries := []LogEntry{
+		{Pid: "a", Clock: ClockMap{"a": 1}},
+		{Pid: "a", Clock: ClockMap{"a": 2, "b": 1}},
+		{Pid: "b", Clock: ClockMap{"a": 2, "b": 1}},
+	}
+	violations := CheckCausality(entries)
+	if len(violations) != 2 {
+		t.Fatalf("Found %d violations, want 2: %v", len(violations), violations)
+	}
+
+	// The receiver logged its events out of order
+	entries = []LogEntry{
+		{Pid: "b", Clock: ClockMap{"b": 2}},
+		{Pid: "b", Clock: ClockMap{"b": 1}},
+	}
+	if violations := CheckCausality(entries); len(violations) != 1 {
+		t.Fatalf("Found %d violations, want 1: %v", len(violations), violations)
+	}
+}
+
+func TestCompactClock(t *testing.T) {
+	index := NewClockIndex([]string{"a", "b", "c"})
+	encoder, decoder := index.NewEncoder(), index.NewDecoder()
+
+	clocks := []ClockMap{
+		{"a": 1},
+		{"a": 2, "b": 1},
+		{"a": 3, "b": 1, "c": 4},
+		{"a": 4, "b": 1, "c": 4, "d": 2},
+	}
+	for i, c := range clocks {
+		compact := encoder.Encode(c)
+		if i == 2 && len(compact.Indexes) != 2 {
+			t.Fatalf("Encoded %d entries of %v, want the 2 changed", len(compact.Indexes), c)
+		}
+		if got := decoder.Decode(compact); !reflect.DeepEqual(got, c) {
+			t.Fatalf("Decoded %v, want %v", got, c)
+		}
+	}
+}
+
+func TestHybridClockSkew(t *testing.T) {
+	wall := time.UnixMilli(1000)
+	slow := NewHybridClock(func() time.Time { return wall })
+	fast := NewHybridClock(func() time.Time { return wall.Add(time.Second) })
+
+	sent := fast.Now()
+	received := slow.Update(sent)
+	if received <= sent {
+		t.Fatalf("Receive %s not after send %s", received, sent)
+	}
+	if received.Wall() != sent.Wall() || received.Logical() != 1 {
+		t.Fatalf("Receive = %s, want the send wall time with counter 1", received)
+	}
+	if next := slow.Now(); next <= received {
+		t.Fatalf("Local event %s not after receive %s", next, received)
+	}
+
+	if parsed, err := ParseHLC(received.String()); err != nil || parsed != received {
+		t.Fatalf("ParseHLC(%q) = %s, %v", received.String(), parsed, err)
+	}
+}
@@ -0,0 +1,126 @@
+package clock
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ClockIndex numbers the process ids of a run, agreed by the launcher and the
+// nodes when the run is prepared, so clocks travel as index and tick pairs.
+type ClockIndex struct {
+	pids  []string
+	index map[string]uint32
+}
+
+// NewClockIndex numbers pids in the given order
+func NewClockIndex(pids []string) *ClockIndex {
+	x := &ClockIndex{
+		pids:  append([]string(nil), pids...),
+		index: make(map[string]uint32, len(pids)),
+	}
+	for i, pid := range x.pids {
+		x.index[pid] = uint32(i)
+	}
+	return x
+}
+
+// Pids returns the indexed process ids in index order
+func (x *ClockIndex) Pids() []string {
+	return append([]string(nil), x.pids...)
+}
+
+// CompactClock is a vector clock encoded by a ClockEncoder: the entries
+// changed since the previous clock of the link, as index and tick pairs, and
+// the entries of processes missing from the index
+type CompactClock struct {
+	Indexes []uint32
+	Ticks   []uint64
+	Extra   ClockMap
+}
+
+func (c *CompactClock) String() string {
+	var b strings.Builder
+	b.WriteString("[")
+	for k, i := range c.Indexes {
+		if k > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "%d:%d", i, c.Ticks[k])
+	}
+	b.WriteString("]")
+	if len(c.Extra) > 0 {
+		fmt.Fprintf(&b, " %s", c.Extra)
+	}
+	return b.String()
+}
+
+// ClockEncoder encodes the clocks sent on a link as the entries changed since
+// the previous one. The link must deliver every clock to the ClockDecoder in
+// order: a lost clock breaks the decoding of the following ones.
+type ClockEncoder struct {
+	mu    sync.Mutex
+	index *ClockIndex
+	last  []uint64
+}
+
+// NewEncoder returns the encoder of the clocks sent on a new link
+func (x *ClockIndex) NewEncoder() *ClockEncoder {
+	return &ClockEncoder{index: x, last: make([]uint64, len(x.pids))}
+}
+
+// Encode returns the entries of c changed since the previous clock encoded
+func (e *ClockEncoder) Encode(c ClockMap) *CompactClock {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	compact := &CompactClock{}
+	for pid, ticks := range c {
+		i, ok := e.index.index[pid]
+		if !ok {
+			if compact.Extra == nil {
+				compact.Extra = make(ClockMap)
+			}
+			compact.Extra[pid] = ticks
+			continue
+		}
+		if ticks != e.last[i] {
+			compact.Indexes = append(compact.Indexes, i)
+			compact.Ticks = append(compact.Ticks, ticks)
+			e.last[i] = ticks
+		}
+	}
+	return compact
+}
+
+// ClockDecoder rebuilds the clocks received on a link
+type ClockDecoder struct {
+	mu    sync.Mutex
+	index *ClockIndex
+	last  []uint64
+}
+
+// NewDecoder returns the decoder of the clocks received on a new link
+func (x *ClockIndex) NewDecoder() *ClockDecoder {
+	return &ClockDecoder{index: x, last: make([]uint64, len(x.pids))}
+}
+
+// Decode returns the clock encoded by the encoder of the link
+func (d *ClockDecoder) Decode(compact *CompactClock) ClockMap {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, i := range compact.Indexes {
+		if int(i) < len(d.last) {
+			d.last[i] = compact.Ticks[k]
+		}
+	}
+	c := make(ClockMap, len(d.last)+len(compact.Extra))
+	for i, ticks := range d.last {
+		if ticks > 0 {
+			c[d.index.pids[i]] = ticks
+		}
+	}
+	for pid, ticks := range compact.Extra {
+		c[pid] = ticks
+	}
+	return c
+}
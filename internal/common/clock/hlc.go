@@ -0,0 +1,136 @@
+package clock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logicalBits is the size of the logical counter of an HLC
+const logicalBits = 16
+
+// HLC is a hybrid logical clock timestamp: the wall clock milliseconds in the
+// high 48 bits and a logical counter in the low 16 bits, so timestamps compare
+// as integers. The zero HLC is a missing timestamp.
+type HLC uint64
+
+// NewHLC returns the timestamp of the given milliseconds and counter
+func NewHLC(wall int64, logical uint16) HLC {
+	return HLC(uint64(wall)<<logicalBits | uint64(logical))
+}
+
+// Wall returns the wall clock milliseconds of the timestamp
+func (h HLC) Wall() int64 {
+	return int64(h >> logicalBits)
+}
+
+// Logical returns the logical counter of the timestamp
+func (h HLC) Logical() uint16 {
+	return uint16(h)
+}
+
+// Time returns the wall clock time of the timestamp
+func (h HLC) Time() time.Time {
+	return time.UnixMilli(h.Wall())
+}
+
+// IsZero reports whether the timestamp is missing
+func (h HLC) IsZero() bool {
+	return h == 0
+}
+
+func (h HLC) String() string {
+	return fmt.Sprintf("%d.%d", h.Wall(), h.Logical())
+}
+
+// ParseHLC parses a timestamp formatted by HLC.String
+func ParseHLC(s string) (HLC, error) {
+	wall, logical, ok := strings.Cut(s, ".")
+	if !ok {
+		return 0, fmt.Errorf("invalid hybrid logical clock %q", s)
+	}
+	w, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hybrid logical clock %q: %w", s, err)
+	}
+	l, err := strconv.ParseUint(logical, 10, logicalBits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hybrid logical clock %q: %w", s, err)
+	}
+	return NewHLC(w, uint16(l)), nil
+}
+
+// MarshalText formats the timestamp as String, for the JSON sink
+func (h HLC) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText parses a timestamp formatted by MarshalText
+func (h *HLC) UnmarshalText(text []byte) error {
+	stamp, err := ParseHLC(string(text))
+	if err != nil {
+		return err
+	}
+	*h = stamp
+	return nil
+}
+
+// HybridClock is a hybrid logical clock: its timestamps follow the wall
+// clock of the process, never go backwards and are greater than the
+// timestamps of the messages received, even when the clocks of the hosts
+// are skewed.
+type HybridClock struct {
+	mu   sync.Mutex
+	now  func() time.Time
+	last HLC
+}
+
+// NewHybridClock returns a hybrid clock reading the wall clock with now,
+// time.Now when nil
+func NewHybridClock(now func() time.Time) *HybridClock {
+	if now == nil {
+		now = time.Now
+	}
+	return &HybridClock{now: now}
+}
+
+// Now returns the timestamp of a local or send event
+func (hc *HybridClock) Now() HLC {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.last = maxHLC(hc.physical(), hc.last+1)
+	return hc.last
+}
+
+// Update returns the timestamp of the receive of a message stamped with
+// remote, a missing stamp is a local event
+func (hc *HybridClock) Update(remote HLC) HLC {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.last = maxHLC(hc.physical(), hc.last+1)
+	if !remote.IsZero() {
+		hc.last = maxHLC(hc.last, remote+1)
+	}
+	return hc.last
+}
+
+// Last returns the timestamp of the last event
+func (hc *HybridClock) Last() HLC {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.last
+}
+
+// physical returns the wall clock as a timestamp with a zero counter
+func (hc *HybridClock) physical() HLC {
+	return NewHLC(hc.now().UnixMilli(), 0)
+}
+
+func maxHLC(a, b HLC) HLC {
+	if a > b {
+		return a
+	}
+	return b
+}
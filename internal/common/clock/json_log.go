@@ -0,0 +1,118 @@
+package clock
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Fields are typed values attached to a logged event
+type Fields map[string]any
+
+// Well known field names
+const (
+	// FieldRun is the simulation run of the event
+	FieldRun = "run"
+	// FieldSegment is the segment logging the event
+	FieldSegment = "segment"
+	// FieldPeer is the other segment of a message
+	FieldPeer = "peer"
+	// FieldEvent is a simulation event
+	FieldEvent = "event"
+	// FieldSimClock is the simulated time of the event
+	FieldSimClock = "simClock"
+	// FieldTrace is the kind of a traced activity
+	FieldTrace = "trace"
+	// FieldTransition is a fired transition
+	FieldTransition = "transition"
+	// FieldMessage is the type of a message
+	FieldMessage = "message"
+	// FieldSeq is the sequence number of a message on its link
+	FieldSeq = "seq"
+	// FieldDuration is the duration of an activity in seconds
+	FieldDuration = "duration"
+)
+
+// LogRecord is a line of the JSON sink of a ClockLogger
+type LogRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Pid     string    `json:"pid"`
+	Clock   ClockMap  `json:"clock"`
+	HLC     HLC       `json:"hlc,omitempty"`
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+	Fields  Fields    `json:"fields,omitempty"`
+}
+
+// jsonSink writes LogRecords as JSON Lines
+type jsonSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	encode func(interface{}) ([]byte, error)
+}
+
+func newJSONSink(w io.Writer, encode func(interface{}) ([]byte, error)) *jsonSink {
+	if encode == nil {
+		encode = json.Marshal
+	}
+	return &jsonSink{w: w, encode: encode}
+}
+
+// write completes the record time and source, calldepth is counted as in
+// log.Logger.Output
+func (s *jsonSink) write(calldepth int, record LogRecord) {
+	record.Time = time.Now()
+	if _, file, line, ok := runtime.Caller(calldepth); ok {
+		record.Source = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	data, err := s.encode(record)
+	if err != nil {
+		log.Printf("Failed to encode log record: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(append(data, '\n'))
+}
+
+// ReadJSONLog reads the records of a JSON sink with decode, json.Unmarshal
+// when nil
+func ReadJSONLog(r io.Reader, decode func([]byte, interface{}) error) ([]LogRecord, error) {
+	if decode == nil {
+		decode = json.Unmarshal
+	}
+	var records []LogRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record LogRecord
+		if err := decode(scanner.Bytes(), &record); err != nil {
+			return records, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Entry converts the record to the entry read from a text log
+func (r LogRecord) Entry() LogEntry {
+	return LogEntry{
+		Time:     r.Time.Format("15:04:05.000000"),
+		Source:   r.Source,
+		Priority: r.Level,
+		Message:  r.Message,
+		Pid:      r.Pid,
+		Clock:    r.Clock,
+		HLC:      r.HLC,
+	}
+}
@@ -0,0 +1,41 @@
+package clock
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONSinkRecords(t *testing.T) {
+	var b bytes.Buffer
+	cl := NewClockLog("sn1", ClockLogConfig{Priority: INFO})
+	cl.json = newJSONSink(&b, nil)
+	cl.LogFieldsf(INFO, ClockMap{"dsl": 3}, Fields{FieldSegment: "subred0", FieldSimClock: 2.5}, "Send event to %s", "subred1")
+	cl.LogDebugf("filtered")
+	cl.LogInfof("line\nbreak")
+
+	records, err := ReadJSONLog(&b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Read %d records, want 2", len(records))
+	}
+	first := records[0]
+	if first.Message != "Send event to subred1" || first.Level != "INFO" || first.Pid != "sn1" {
+		t.Fatalf("Unexpected record %+v", first)
+	}
+	if !reflect.DeepEqual(first.Clock, ClockMap{"dsl": 3, "sn1": 1}) {
+		t.Fatalf("Clock = %v", first.Clock)
+	}
+	if first.Fields[FieldSegment] != "subred0" || first.Fields[FieldSimClock] != 2.5 {
+		t.Fatalf("Fields = %v", first.Fields)
+	}
+	if !strings.HasPrefix(first.Source, "json_log_test.go:") {
+		t.Fatalf("Source = %s, want the caller of LogFieldsf", first.Source)
+	}
+	if !strings.HasPrefix(records[1].Source, "json_log_test.go:") || records[1].Message != "line\nbreak" {
+		t.Fatalf("Unexpected record %+v", records[1])
+	}
+}
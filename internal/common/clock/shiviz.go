@@ -0,0 +1,154 @@
+package clock
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ShiVizRegex parses the events of a ShiViz stream, it is the first line of
+// the files written by WriteShiViz
+const ShiVizRegex = `(?<date>(\d{2}:){2}\d{2}.\d{6}) (?<path>\S*): \[(?<priority>(DEBUG|INFO|WARNING|ERROR|FATAL))\]( hlc=(?<hlc>\d+\.\d+))? - (?<event>.*)\n(?<host>\S*) (?<clock>{.*})`
+
+var (
+	// logHeaderPattern matches the first line of a logged event
+	logHeaderPattern = regexp.MustCompile(`^(\d{2}:\d{2}:\d{2}\.\d{6}) (\S+): \[(\w+)\](?: hlc=(\S+))? - (.*)$`)
+	// logClockPattern matches the last line of a logged event
+	logClockPattern = regexp.MustCompile(`^(\S+) (\{.*\})$`)
+)
+
+// EscapeShiVizEvent keeps an event message on a single line, as ShiViz
+// expects every event to be followed by its clock line
+func EscapeShiVizEvent(message string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(message)
+}
+
+// UnescapeShiVizEvent restores an event message escaped by
+// EscapeShiVizEvent
+func UnescapeShiVizEvent(event string) string {
+	var b strings.Builder
+	for i := 0; i < len(event); i++ {
+		if event[i] != '\\' || i == len(event)-1 {
+			b.WriteByte(event[i])
+			continue
+		}
+		i++
+		switch event[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(event[i])
+		}
+	}
+	return b.String()
+}
+
+// LogEntry is an event read from a ClockLogger log or ShiViz stream
+type LogEntry struct {
+	Time     string
+	Source   string
+	Priority string
+	// Message is the event message, multi-line messages keep their newlines
+	Message string
+	Pid     string
+	Clock   ClockMap
+	// HLC is the hybrid logical clock stamp of the event, zero when the
+	// log was written without stamps
+	HLC HLC
+}
+
+// ReadLog reads the events of a ClockLogger log. The lines between the
+// header and the clock line of an event are part of its message.
+func ReadLog(r io.Reader) ([]LogEntry, error) {
+	var (
+		entries []LogEntry
+		pending *LogEntry
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if pending != nil {
+			if m := logClockPattern.FindStringSubmatch(text); m != nil {
+				var clockMap ClockMap
+				if err := json.Unmarshal([]byte(m[2]), &clockMap); err == nil {
+					pending.Pid, pending.Clock = m[1], clockMap
+					entries = append(entries, *pending)
+					pending = nil
+					continue
+				}
+			}
+			pending.Message += "\n" + text
+			continue
+		}
+		m := logHeaderPattern.FindStringSubmatch(text)
+		if m == nil {
+			return entries, fmt.Errorf("line %d: event header expected: %q", line, text)
+		}
+		pending = &LogEntry{Time: m[1], Source: m[2], Priority: m[3], Message: m[5]}
+		if m[4] != "" {
+			stamp, err := ParseHLC(m[4])
+			if err != nil {
+				return entries, fmt.Errorf("line %d: %w", line, err)
+			}
+			pending.HLC = stamp
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, err
+	}
+	if pending != nil {
+		return entries, fmt.Errorf("event %q without clock line", pending.Message)
+	}
+	return entries, nil
+}
+
+// ReadShiViz reads the events of a ShiViz stream, with or without the
+// header written by WriteShiViz, unescaping their messages
+func ReadShiViz(r io.Reader) ([]LogEntry, error) {
+	br := bufio.NewReader(r)
+	header := ShiVizRegex + "\n\n"
+	if prefix, _ := br.Peek(len(header)); string(prefix) == header {
+		br.Discard(len(header))
+	}
+	entries, err := ReadLog(br)
+	for i := range entries {
+		entries[i].Message = UnescapeShiVizEvent(entries[i].Message)
+	}
+	return entries, err
+}
+
+// WriteShiViz writes entries as a ShiViz log, escaping multi-line messages
+func WriteShiViz(w io.Writer, entries []LogEntry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s\n\n", ShiVizRegex)
+	for _, e := range entries {
+		priority := "[" + e.Priority + "]"
+		if !e.HLC.IsZero() {
+			priority += " hlc=" + e.HLC.String()
+		}
+		fmt.Fprintf(bw, "%s %s: %s - %s\n%s %s\n", e.Time, e.Source, priority, EscapeShiVizEvent(e.Message), e.Pid, e.Clock)
+	}
+	return bw.Flush()
+}
+
+// SortByHLC sorts the events of several logs by their hybrid logical clock
+// stamps, keeping the order of equal stamps. Logs are left unsorted and false
+// is returned when an event is not stamped.
+func SortByHLC(entries []LogEntry) bool {
+	for _, e := range entries {
+		if e.HLC.IsZero() {
+			return false
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].HLC < entries[j].HLC
+	})
+	return true
+}
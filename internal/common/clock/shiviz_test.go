@@ -0,0 +1,96 @@
+package clock
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLogMultiLineMessage(t *testing.T) {
+	log := `00:31:00.082888 run.go:10: [INFO] - Events:
+[{1 2}
+ {3 4}]
+sn1 {"dsl":2, "sn1":5}
+00:31:00.082900 run.go:12: [DEBUG] - done
+sn1 {"dsl":2, "sn1":6}
+`
+	entries, err := ReadLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Read %d entries, want 2", len(entries))
+	}
+	if entries[0].Message != "Events:\n[{1 2}\n {3 4}]" {
+		t.Fatalf("Message = %q", entries[0].Message)
+	}
+	if !reflect.DeepEqual(entries[1].Clock, ClockMap{"dsl": 2, "sn1": 6}) || entries[1].Pid != "sn1" {
+		t.Fatalf("Clock line read as %s %v", entries[1].Pid, entries[1].Clock)
+	}
+}
+
+func TestWriteShiVizEscapesEvents(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteShiViz(&b, []LogEntry{{
+		Time: "00:31:00.082888", Source: "run.go:10", Priority: "INFO",
+		Message: "a\nb\\c", Pid: "sn1", Clock: ClockMap{"sn1": 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ShiVizRegex + "\n\n" + `00:31:00.082888 run.go:10: [INFO] - a\nb\\c` + "\n" + `sn1 {"sn1":1}` + "\n"
+	if b.String() != want {
+		t.Fatalf("WriteShiViz wrote\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestReadShiVizRoundTrip(t *testing.T) {
+	entries := []LogEntry{{
+		Time: "00:31:00.082888", Source: "run.go:10", Priority: "INFO",
+		Message: "a\nb\\c\\nd\r", Pid: "sn1", Clock: ClockMap{"sn1": 1},
+	}}
+	var b bytes.Buffer
+	if err := WriteShiViz(&b, entries); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadShiViz(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Fatalf("Read %+v, want %+v", read, entries)
+	}
+
+	// Streams of the ClockLogger sink have no header
+	stream := `00:31:00.082888 run.go:10: [INFO] - a\nb` + "\n" + `sn1 {"sn1":1}` + "\n"
+	read, err = ReadShiViz(strings.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 1 || read[0].Message != "a\nb" {
+		t.Fatalf("Read %+v from the stream", read)
+	}
+}
+
+func TestSortByHLC(t *testing.T) {
+	log := `00:31:00.082888 run.go:10: [INFO] hlc=2000.0 - sent
+sn1 {"sn1":1}
+00:30:59.000000 run.go:12: [INFO] hlc=2000.1 - received
+sn2 {"sn1":1, "sn2":1}
+`
+	entries, err := ReadLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[0], entries[1] = entries[1], entries[0]
+	if !SortByHLC(entries) {
+		t.Fatal("Stamped entries not sorted")
+	}
+	if entries[0].Message != "sent" || entries[1].HLC != NewHLC(2000, 1) {
+		t.Fatalf("Sorted entries %+v", entries)
+	}
+	if SortByHLC(append(entries, LogEntry{Message: "unstamped"})) {
+		t.Fatal("Entries without stamp sorted")
+	}
+}
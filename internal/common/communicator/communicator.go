@@ -4,6 +4,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"net"
+	"time"
 )
 
 type ConnectionHandlerCallback func(net.Conn)
@@ -47,3 +48,90 @@ func SendReceiveTCP(address string, message interface{}) (interface{}, error) {
 
 	return Receive(conn)
 }
+
+// SendReceiveTCPTimeout behaves like SendReceiveTCP but bounds both the dial and
+// the whole request/response exchange by timeout.
+func SendReceiveTCPTimeout(address string, message interface{}, timeout time.Duration) (interface{}, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to node: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	encoder := gob.NewEncoder(conn)
+	if err = encoder.Encode(&message); err != nil {
+		return nil, fmt.Errorf("error sending event to node: %v", err)
+	}
+
+	return Receive(conn)
+}
+
+// RetryPolicy controls how SendReceiveTCPRetry deals with transient failures
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first one
+	Attempts int
+	// Backoff is the wait before the first retry, doubled after every retry
+	Backoff time.Duration
+	// MaxBackoff caps the wait between two attempts
+	MaxBackoff time.Duration
+	// Timeout is the deadline of every single attempt
+	Timeout time.Duration
+}
+
+// DefaultRetryPolicy is used when a zero RetryPolicy is given
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Backoff:    100 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+	Timeout:    5 * time.Second,
+}
+
+// SendReceiveTCPRetry sends message to address and waits for the response,
+// retrying with exponential backoff while the message could not be delivered.
+// Once the message has been written to the connection it is never sent again,
+// so receivers do not have to deal with duplicated messages.
+func SendReceiveTCPRetry(address string, message interface{}, policy RetryPolicy) (interface{}, error) {
+	if policy == (RetryPolicy{}) {
+		policy = DefaultRetryPolicy
+	}
+
+	backoff := policy.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var (
+			response interface{}
+			sent     bool
+		)
+		if response, sent, err = sendReceiveAttempt(address, message, policy.Timeout); err == nil {
+			return response, nil
+		}
+		if sent || attempt >= policy.Attempts {
+			return nil, fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func sendReceiveAttempt(address string, message interface{}, timeout time.Duration) (interface{}, bool, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, false, fmt.Errorf("error connecting to node: %v", err)
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	encoder := gob.NewEncoder(conn)
+	if err = encoder.Encode(&message); err != nil {
+		return nil, false, fmt.Errorf("error sending message to node: %v", err)
+	}
+
+	response, err := Receive(conn)
+	if err != nil {
+		return nil, true, fmt.Errorf("error receiving response from node: %v", err)
+	}
+	return response, true, nil
+}
@@ -0,0 +1,104 @@
+package communicator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrorCode identifies a failure reported in a Response
+type ErrorCode int
+
+// Generic error codes, packages using the communicator register their own
+// codes starting at FirstUserErrorCode.
+const (
+	ErrUnknown ErrorCode = iota
+	ErrUnhandledMessage
+)
+
+// FirstUserErrorCode is the first code available for RegisterErrorCode
+const FirstUserErrorCode ErrorCode = 100
+
+var (
+	errorCodesMutex sync.RWMutex
+	errorCodes      = map[ErrorCode]string{
+		ErrUnknown:          "unknown",
+		ErrUnhandledMessage: "unhandled message",
+	}
+)
+
+// RegisterErrorCode gives a name to an error code. It panics if the code is
+// already registered, so clashes are detected at init time.
+func RegisterErrorCode(code ErrorCode, name string) ErrorCode {
+	errorCodesMutex.Lock()
+	defer errorCodesMutex.Unlock()
+	if registered, ok := errorCodes[code]; ok {
+		panic(fmt.Sprintf("communicator: error code %d already registered as %q", code, registered))
+	}
+	errorCodes[code] = name
+	return code
+}
+
+func (c ErrorCode) String() string {
+	errorCodesMutex.RLock()
+	defer errorCodesMutex.RUnlock()
+	if name, ok := errorCodes[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("error code %d", int(c))
+}
+
+// Error is the error sent on the wire inside a Response. Unlike arbitrary
+// error values it only holds plain fields, so it is always gob encodable.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Details map[string]string
+}
+
+// NewError returns an Error with the given code and formatted message
+func NewError(code ErrorCode, format string, v ...any) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, v...),
+	}
+}
+
+// AsError returns the Error wrapped by err, so its code survives being sent
+// to another node. Other errors are reported as ErrUnknown.
+func AsError(err error) *Error {
+	var cerr *Error
+	if errors.As(err, &cerr) {
+		return cerr
+	}
+	return NewError(ErrUnknown, "%s", err)
+}
+
+// WithDetail adds a key value pair to the error details
+func (e *Error) WithDetail(key, value string) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]string)
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s", e.Code, e.Message)
+	if len(e.Details) > 0 {
+		keys := make([]string, 0, len(e.Details))
+		for k := range e.Details {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		details := make([]string, len(keys))
+		for i, k := range keys {
+			details[i] = fmt.Sprintf("%s=%s", k, e.Details[k])
+		}
+		fmt.Fprintf(&sb, " (%s)", strings.Join(details, ", "))
+	}
+	return sb.String()
+}
@@ -0,0 +1,59 @@
+package communicator
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorResponseGobRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	var message interface{} = Response{Error: NewError(ErrUnhandledMessage, "not handled").WithDetail("node", "sn1")}
+	if err := gob.NewEncoder(&buffer).Encode(&message); err != nil {
+		t.Fatalf("Failed encoding response: %v", err)
+	}
+
+	var data interface{}
+	if err := gob.NewDecoder(&buffer).Decode(&data); err != nil {
+		t.Fatalf("Failed decoding response: %v", err)
+	}
+
+	response, ok := data.(Response)
+	if !ok {
+		t.Fatalf("Decoded %T instead of Response", data)
+	}
+	var cerr *Error
+	if !errors.As(response.Error, &cerr) || cerr.Code != ErrUnhandledMessage || cerr.Details["node"] != "sn1" {
+		t.Fatalf("Error not decoded as expected: %v", response.Error)
+	}
+	if response.Error.Error() != "unhandled message: not handled (node=sn1)" {
+		t.Fatalf("Unexpected error message: %s", response.Error)
+	}
+}
+
+func TestSuccessResponseHasNilError(t *testing.T) {
+	var buffer bytes.Buffer
+	var message interface{} = Response{}
+	if err := gob.NewEncoder(&buffer).Encode(&message); err != nil {
+		t.Fatalf("Failed encoding response: %v", err)
+	}
+	var data interface{}
+	if err := gob.NewDecoder(&buffer).Decode(&data); err != nil {
+		t.Fatalf("Failed decoding response: %v", err)
+	}
+	if data.(Response).Error != nil {
+		t.Fatalf("Success response decoded with error: %v", data.(Response).Error)
+	}
+}
+
+func TestAsErrorKeepsWrappedCode(t *testing.T) {
+	wrapped := fmt.Errorf("link a -> b: %w", NewError(ErrUnhandledMessage, "not handled"))
+	if err := AsError(wrapped); err.Code != ErrUnhandledMessage || err.Message != "not handled" {
+		t.Fatalf("Wrapped error converted to %v", err)
+	}
+	if err := AsError(errors.New("connection refused")); err.Code != ErrUnknown || err.Message != "connection refused" {
+		t.Fatalf("Plain error converted to %v", err)
+	}
+}
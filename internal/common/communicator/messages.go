@@ -1,6 +1,14 @@
 package communicator
 
-import "github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+import (
+	"encoding/gob"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+)
+
+func init() {
+	gob.Register(Response{})
+}
 
 type Request struct {
 	clock.ClockPayload
@@ -9,24 +17,5 @@ type Request struct {
 type Response struct {
 	clock.ClockPayload
 	Message string
-	Error   error
-}
-
-func RequestWithClock(pid string, cc clock.ClockMap) Request {
-	return Request{
-		ClockPayload: clock.ClockPayload{
-			Pid:   pid,
-			Clock: cc,
-		},
-	}
-}
-
-func ResponseWithClock(pid string, cc clock.ClockMap, success bool) Response {
-	return Response{
-		ClockPayload: clock.ClockPayload{
-			Pid:   pid,
-			Clock: cc,
-		},
-		// Success: success,
-	}
+	Error   *Error
 }
@@ -0,0 +1,300 @@
+// Package metrics exposes counters, gauges and histograms in the Prometheus
+// text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of message
+// round-trip latencies
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// Metric is a family of series sharing a name, a type and label names
+type Metric interface {
+	// WriteTo writes the family in the text format
+	WriteTo(w io.Writer) (int64, error)
+}
+
+// family holds the series of a metric keyed by their label values
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	// counts and sum of a histogram, counts are per bucket and not cumulative
+	counts []uint64
+	sum    float64
+}
+
+func newFamily(name, help, kind string, labels []string) *family {
+	return &family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+// get returns the series of the label values, creating it
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s: %d label values for labels %v", f.name, len(values), f.labels))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values
+func (f *family) sorted() []*series {
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+	return all
+}
+
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// writeValues writes the counter or gauge series of the family
+func (f *family) writeValues(w io.Writer) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	f.header(bw)
+	for _, s := range f.sorted() {
+		fmt.Fprintf(bw, "%s%s %s\n", f.name, labelPairs(f.labels, s.values, "", ""), formatFloat(s.value))
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Counter is a family of series that only go up
+type Counter struct {
+	f *family
+}
+
+// NewCounter returns a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{newFamily(name, help, "counter", labels)}
+}
+
+// Add adds v, which must not be negative, to the series of the label values
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metric %s: counter decreased by %v", c.f.name, v))
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(values).value += v
+}
+
+// Inc adds one to the series of the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// WriteTo writes the counter in the text format
+func (c *Counter) WriteTo(w io.Writer) (int64, error) {
+	return c.f.writeValues(w)
+}
+
+// Gauge is a family of series that go up and down
+type Gauge struct {
+	f *family
+}
+
+// NewGauge returns a gauge with the given label names
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{newFamily(name, help, "gauge", labels)}
+}
+
+// Set sets the series of the label values to v
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(values).value = v
+}
+
+// WriteTo writes the gauge in the text format
+func (g *Gauge) WriteTo(w io.Writer) (int64, error) {
+	return g.f.writeValues(w)
+}
+
+// Histogram is a family of series counting observations in buckets
+type Histogram struct {
+	f       *family
+	buckets []float64
+}
+
+// NewHistogram returns a histogram with the given bucket upper bounds, in
+// increasing order, and label names. The +Inf bucket is implicit.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{newFamily(name, help, "histogram", labels), buckets}
+}
+
+// Observe adds an observation to the series of the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets)+1)
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+}
+
+// WriteTo writes the histogram in the text format
+func (h *Histogram) WriteTo(w io.Writer) (int64, error) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	h.f.header(bw)
+	for _, s := range h.f.sorted() {
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", h.f.name, labelPairs(h.f.labels, s.values, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(bw, "%s_sum%s %s\n", h.f.name, labelPairs(h.f.labels, s.values, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(bw, "%s_count%s %d\n", h.f.name, labelPairs(h.f.labels, s.values, "", ""), cumulative)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Registry serves the metrics registered and the metrics built on every
+// scrape by its collectors
+type Registry struct {
+	mu         sync.Mutex
+	metrics    []Metric
+	collectors []func() []Metric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds metrics written on every scrape
+func (r *Registry) Register(metrics ...Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, metrics...)
+}
+
+// RegisterCollector adds a function returning metrics built on every
+// scrape, for values read from snapshots of other state
+func (r *Registry) RegisterCollector(collect func() []Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collect)
+}
+
+// WriteTo writes every metric in the text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]Metric(nil), r.metrics...)
+	collectors := append([]func() []Metric(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, collect := range collectors {
+		metrics = append(metrics, collect()...)
+	}
+	var total int64
+	for _, m := range metrics {
+		n, err := m.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ServeHTTP serves the metrics to a Prometheus scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+func labelPairs(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+labelEscaper.Replace(extraValue)+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	// labelEscaper escapes label values as the text format expects
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryTextFormat(t *testing.T) {
+	sent := NewCounter("messages_sent_total", "Messages sent.", "link")
+	sent.Inc("a->b")
+	sent.Add(2, `say "hi"`)
+	latency := NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "link")
+	latency.Observe(0.05, "a->b")
+	latency.Observe(1, "a->b")
+	latency.Observe(3, "a->b")
+
+	registry := NewRegistry()
+	registry.Register(sent, latency)
+	registry.RegisterCollector(func() []Metric {
+		g := NewGauge("clock", "Simulated clock.")
+		g.Set(2.5)
+		return []Metric{g}
+	})
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); got != ContentType {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	want := `# HELP messages_sent_total Messages sent.
+# TYPE messages_sent_total counter
+messages_sent_total{link="a->b"} 1
+messages_sent_total{link="say \"hi\""} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{link="a->b",le="0.1"} 1
+latency_seconds_bucket{link="a->b",le="1"} 2
+latency_seconds_bucket{link="a->b",le="+Inf"} 3
+latency_seconds_sum{link="a->b"} 4.05
+latency_seconds_count{link="a->b"} 3
+# HELP clock Simulated clock.
+# TYPE clock gauge
+clock 2.5
+`
+	if got := recorder.Body.String(); got != want {
+		t.Fatalf("Scrape returned\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelValuesMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Missing label value not reported")
+		}
+	}()
+	NewCounter("c", "C.", "a", "b").Inc("only a")
+}
+
+func TestCounterDoesNotDecrease(t *testing.T) {
+	c := NewCounter("c", "C.")
+	c.Add(1)
+	var b strings.Builder
+	c.WriteTo(&b)
+	if !strings.Contains(b.String(), "c 1\n") {
+		t.Fatalf("Counter written as %q", b.String())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Counter decreased")
+		}
+	}()
+	c.Add(-1)
+}
@@ -0,0 +1,51 @@
+package dsim
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+)
+
+// DurationDistribution is the distribution of the firing durations of the
+// transitions of a run
+type DurationDistribution string
+
+const (
+	// DurationsDeterministic fires every transition for its LEF duration
+	DurationsDeterministic DurationDistribution = "deterministic"
+	// DurationsShiftedExponential adds to the LEF duration an exponential
+	// delay of the same mean, so the mean duration is twice the LEF one. The
+	// LEF duration stays the minimum, so the lookaheads computed from it
+	// remain valid.
+	DurationsShiftedExponential DurationDistribution = "shiftedExponential"
+)
+
+// Validate checks the distribution is known, empty is deterministic
+func (d DurationDistribution) Validate() error {
+	switch d {
+	case "", DurationsDeterministic, DurationsShiftedExponential:
+		return nil
+	}
+	return fmt.Errorf("unknown duration distribution %q", d)
+}
+
+// Stochastic tells whether firing durations are drawn at random
+func (d DurationDistribution) Stochastic() bool {
+	return d == DurationsShiftedExponential
+}
+
+// sample draws a firing duration of a transition with LEF duration duration
+func (d DurationDistribution) sample(rng *rand.Rand, duration Clock) Clock {
+	if d == DurationsShiftedExponential && duration > 0 {
+		return duration + Clock(rng.ExpFloat64())*duration
+	}
+	return duration
+}
+
+// SegmentSeed derives the seed of the random stream of a segment from the
+// seed of its run, so the segments of a run draw independent streams
+func SegmentSeed(seed int64, segment string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(segment))
+	return seed ^ int64(h.Sum64())
+}
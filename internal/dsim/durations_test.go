@@ -0,0 +1,25 @@
+package dsim
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestShiftedExponentialDurations(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var s Summary
+	for i := 0; i < 100000; i++ {
+		duration := DurationsShiftedExponential.sample(rng, 2)
+		if duration < 2 {
+			t.Fatalf("Sampled %v below the LEF duration", duration)
+		}
+		s.Add(float64(duration))
+	}
+	// The mean is the LEF duration plus an exponential delay of its mean
+	if s.Mean < 3.95 || s.Mean > 4.05 {
+		t.Fatalf("Mean duration %v, want 4", s.Mean)
+	}
+	if d := DurationsDeterministic.sample(rng, 2); d != 2 {
+		t.Fatalf("Deterministic duration %v, want 2", d)
+	}
+}
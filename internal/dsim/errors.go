@@ -0,0 +1,53 @@
+package dsim
+
+import (
+	"fmt"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
+)
+
+// AbortReason identifies why a simulation was aborted. Its value is used as
+// the exit status of the dsim-node and dsim-launcher processes.
+type AbortReason int
+
+const (
+	// AbortNone means the simulation was not aborted
+	AbortNone AbortReason = iota
+	// AbortInterrupted is used when a process receives a termination signal
+	AbortInterrupted
+	// AbortLauncherError is used when the launcher fails to set up or start the simulation
+	AbortLauncherError
+	// AbortNodeFailure is used when a simulation node fails during the run
+	AbortNodeFailure
+)
+
+var abortReasonLookup = [...]string{
+	AbortNone:          "none",
+	AbortInterrupted:   "interrupted",
+	AbortLauncherError: "launcher error",
+	AbortNodeFailure:   "node failure",
+}
+
+func (r AbortReason) String() string {
+	if r >= 0 && int(r) < len(abortReasonLookup) {
+		return abortReasonLookup[r]
+	}
+	return fmt.Sprintf("unknown (%d)", int(r))
+}
+
+// ExitCode returns the process exit status for the reason.
+func (r AbortReason) ExitCode() int {
+	return int(r)
+}
+
+// Error codes sent in responses by simulation nodes
+var (
+	ErrEngineAlreadyInitialized = communicator.RegisterErrorCode(communicator.FirstUserErrorCode, "simulation engine already initialized")
+	ErrEngineNotInitialized     = communicator.RegisterErrorCode(communicator.FirstUserErrorCode+1, "simulation engine not initialized")
+	ErrEngineAlreadyRunning     = communicator.RegisterErrorCode(communicator.FirstUserErrorCode+2, "simulation engine already running")
+	ErrUnknownSegment           = communicator.RegisterErrorCode(communicator.FirstUserErrorCode+3, "unknown segment")
+	ErrNodeActive               = communicator.RegisterErrorCode(communicator.FirstUserErrorCode+4, "node still active")
+	ErrUnknownRun               = communicator.RegisterErrorCode(communicator.FirstUserErrorCode+5, "unknown run")
+	ErrInvalidTopology          = communicator.RegisterErrorCode(communicator.FirstUserErrorCode+6, "invalid topology")
+	ErrUnknownNode              = communicator.RegisterErrorCode(communicator.FirstUserErrorCode+7, "unknown node")
+)
@@ -0,0 +1,255 @@
+package dsim
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+)
+
+// Estimate is the confidence interval of the mean of a measure, estimated
+// from independent samples such as replications or batches
+type Estimate struct {
+	Mean     float64
+	Variance float64
+	// HalfWidth is the half width of the confidence interval, 0 with less
+	// than two samples
+	HalfWidth float64
+	Samples   int
+}
+
+// NewEstimate estimates the mean of samples at the confidence level
+func NewEstimate(samples []float64, confidence float64) Estimate {
+	var s Summary
+	for _, x := range samples {
+		s.Add(x)
+	}
+	e := Estimate{Mean: s.Mean, Variance: s.Variance(), Samples: len(samples)}
+	if len(samples) > 1 {
+		e.HalfWidth = studentQuantile(1-(1-confidence)/2, len(samples)-1) * math.Sqrt(e.Variance/float64(len(samples)))
+	}
+	return e
+}
+
+func (e Estimate) String() string {
+	return fmt.Sprintf("%.4f ± %.4f", e.Mean, e.HalfWidth)
+}
+
+// TransitionEstimates are the estimates of the measures of a transition
+type TransitionEstimates struct {
+	Transition  TransitionId
+	Segment     string
+	Throughput  Estimate
+	MeanValue   Estimate
+	Utilization Estimate
+}
+
+// Estimation methods
+const (
+	EstimateReplicationsMethod = "replications"
+	EstimateBatchMeansMethod   = "batch means"
+)
+
+// Estimates are the estimates of the measures of a model
+type Estimates struct {
+	Method     string
+	Confidence float64
+	Samples    int
+	// Throughput is the estimate of the firings of every transition per
+	// simulated time unit
+	Throughput  Estimate
+	Transitions []TransitionEstimates
+}
+
+// estimates builds the estimates from the measures of every sample, samples
+// missing a transition are skipped
+func estimates(method string, confidence float64, throughputs []float64, samples []map[TransitionId]TransitionStatistics, measures func(TransitionStatistics, int) Measures) Estimates {
+	e := Estimates{
+		Method:     method,
+		Confidence: confidence,
+		Samples:    len(throughputs),
+		Throughput: NewEstimate(throughputs, confidence),
+	}
+	segments := make(map[TransitionId]string)
+	for _, sample := range samples {
+		for id, ts := range sample {
+			segments[id] = ts.Segment
+		}
+	}
+	for id, segment := range segments {
+		var throughput, meanValue, utilization []float64
+		for i, sample := range samples {
+			ts, ok := sample[id]
+			if !ok {
+				continue
+			}
+			m := measures(ts, i)
+			throughput = append(throughput, m.Throughput)
+			meanValue = append(meanValue, m.MeanValue)
+			utilization = append(utilization, m.Utilization)
+		}
+		e.Transitions = append(e.Transitions, TransitionEstimates{
+			Transition:  id,
+			Segment:     segment,
+			Throughput:  NewEstimate(throughput, confidence),
+			MeanValue:   NewEstimate(meanValue, confidence),
+			Utilization: NewEstimate(utilization, confidence),
+		})
+	}
+	sort.Slice(e.Transitions, func(i, j int) bool {
+		return e.Transitions[i].Transition < e.Transitions[j].Transition
+	})
+	return e
+}
+
+// EstimateReplications estimates the measures of a model from the
+// statistics of independent replications of its run
+func EstimateReplications(runs []RunStatistics, confidence float64) Estimates {
+	throughputs := make([]float64, len(runs))
+	samples := make([]map[TransitionId]TransitionStatistics, len(runs))
+	for i, run := range runs {
+		throughputs[i] = run.Throughput
+		samples[i] = make(map[TransitionId]TransitionStatistics, len(run.Transitions))
+		for _, ts := range run.Transitions {
+			samples[i][ts.Transition] = ts
+		}
+	}
+	return estimates(EstimateReplicationsMethod, confidence, throughputs, samples, func(ts TransitionStatistics, _ int) Measures {
+		return ts.Measures
+	})
+}
+
+// EstimateBatchMeans estimates the measures of a model from the batches of a
+// single long run. The batches must be long enough for their means to be
+// nearly independent.
+func EstimateBatchMeans(run RunStatistics, confidence float64) Estimates {
+	batches := 0
+	for _, ts := range run.Transitions {
+		if len(ts.Batches) > batches {
+			batches = len(ts.Batches)
+		}
+	}
+	throughputs := make([]float64, batches)
+	samples := make([]map[TransitionId]TransitionStatistics, batches)
+	for b := range samples {
+		samples[b] = make(map[TransitionId]TransitionStatistics)
+		for _, ts := range run.Transitions {
+			if b < len(ts.Batches) {
+				samples[b][ts.Transition] = ts
+				throughputs[b] += ts.Batches[b].Throughput
+			}
+		}
+	}
+	return estimates(EstimateBatchMeansMethod, confidence, throughputs, samples, func(ts TransitionStatistics, b int) Measures {
+		return ts.Batches[b]
+	})
+}
+
+// Print writes the estimates
+func (e Estimates) Print(w io.Writer) {
+	fmt.Fprintf(w, "Estimates from %d %s at %g%% confidence: throughput %s\n",
+		e.Samples, e.Method, e.Confidence*100, e.Throughput)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TRANSITION\tSEGMENT\tTHROUGHPUT\tVARIANCE\tMEAN VALUE\tVARIANCE\tUTILIZATION\tVARIANCE")
+	for _, te := range e.Transitions {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%.4g\t%s\t%.4g\t%s\t%.4g\n", te.Transition, te.Segment,
+			te.Throughput, te.Throughput.Variance,
+			te.MeanValue, te.MeanValue.Variance,
+			te.Utilization, te.Utilization.Variance)
+	}
+	tw.Flush()
+}
+
+// studentQuantile returns the p quantile of the Student's t distribution
+// with dof degrees of freedom, found by bisection of its distribution
+// function
+func studentQuantile(p float64, dof int) float64 {
+	if p == 0.5 {
+		return 0
+	}
+	if p < 0.5 {
+		return -studentQuantile(1-p, dof)
+	}
+	lo, hi := 0.0, 1.0
+	for studentCDF(hi, dof) < p {
+		lo, hi = hi, hi*2
+	}
+	for i := 0; i < 100 && hi-lo > 1e-12; i++ {
+		mid := (lo + hi) / 2
+		if studentCDF(mid, dof) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// studentCDF returns the distribution function of the Student's t
+// distribution with dof degrees of freedom
+func studentCDF(t float64, dof int) float64 {
+	v := float64(dof)
+	tail := 0.5 * regularizedBeta(v/(v+t*t), v/2, 0.5)
+	if t > 0 {
+		return 1 - tail
+	}
+	return tail
+}
+
+// regularizedBeta returns the regularized incomplete beta function I_x(a, b),
+// evaluated with the continued fraction of Numerical Recipes
+func regularizedBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges fast below the mean
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaFraction(1-x, b, a)/b
+	}
+	return front * betaFraction(x, a, b) / a
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function with the modified Lentz's method
+func betaFraction(x, a, b float64) float64 {
+	const (
+		tiny    = 1e-300
+		epsilon = 1e-15
+	)
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	f := d
+	for m := 1; m <= 300; m++ {
+		fm := float64(m)
+		for _, numerator := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + numerator*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + numerator/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			f *= d * c
+		}
+		if math.Abs(d*c-1) < epsilon {
+			break
+		}
+	}
+	return f
+}
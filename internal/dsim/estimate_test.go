@@ -0,0 +1,54 @@
+package dsim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStudentQuantile(t *testing.T) {
+	for _, tc := range []struct {
+		p    float64
+		dof  int
+		want float64
+	}{
+		{0.975, 1, 12.7062},
+		{0.975, 4, 2.7764},
+		{0.975, 30, 2.0423},
+		{0.95, 9, 1.8331},
+		{0.995, 2, 9.9248},
+		{0.025, 4, -2.7764},
+	} {
+		if got := studentQuantile(tc.p, tc.dof); math.Abs(got-tc.want) > 1e-3 {
+			t.Errorf("studentQuantile(%v, %d) = %v, want %v", tc.p, tc.dof, got, tc.want)
+		}
+	}
+}
+
+func TestEstimates(t *testing.T) {
+	e := NewEstimate([]float64{1, 2, 3, 4, 5}, 0.95)
+	if e.Mean != 3 || e.Variance != 2.5 || math.Abs(e.HalfWidth-2.7764*math.Sqrt(0.5)) > 1e-3 {
+		t.Fatalf("Estimate %+v", e)
+	}
+
+	run := RunStatistics{
+		Transitions: []TransitionStatistics{
+			{Transition: 0, Segment: "a", Batches: []Measures{{Throughput: 1}, {Throughput: 3}}},
+			{Transition: 1, Segment: "b", Batches: []Measures{{Throughput: 2, Utilization: 0.5}, {Throughput: 2, Utilization: 0.7}}},
+		},
+	}
+	batchMeans := EstimateBatchMeans(run, 0.9)
+	if batchMeans.Samples != 2 || batchMeans.Throughput.Mean != 4 || batchMeans.Throughput.Variance != 2 {
+		t.Fatalf("Batch means %+v", batchMeans)
+	}
+	if u := batchMeans.Transitions[1].Utilization; math.Abs(u.Mean-0.6) > 1e-9 || u.Samples != 2 {
+		t.Fatalf("Utilization %+v", u)
+	}
+
+	replications := EstimateReplications([]RunStatistics{
+		{Throughput: 1, Transitions: []TransitionStatistics{{Transition: 0, Measures: Measures{Throughput: 1}}}},
+		{Throughput: 3, Transitions: []TransitionStatistics{{Transition: 0, Measures: Measures{Throughput: 3}}}},
+	}, 0.95)
+	if replications.Throughput.Mean != 2 || replications.Transitions[0].Throughput.HalfWidth <= 0 {
+		t.Fatalf("Replications %+v", replications)
+	}
+}
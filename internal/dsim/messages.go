@@ -4,20 +4,35 @@ import (
 	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
 )
 
+// PrepareSimulationRequest creates the engine of a segment on a node. A node
+// hosts as many segments as it is sent prepare requests before starting.
 type PrepareSimulationRequest struct {
 	communicator.Request
-	Lefs                 Lefs
-	TransitionNodes      map[TransitionId]TransitionNode
-	WaitingOnSegments    []string
-	NotificationSegments []TransitionNode
+	Run             RunId
+	LauncherAddress string
+	Segment         string
+	Lefs            Lefs
+	Lookahead       Clock
+	// Topology is the link graph of every segment of the run
+	Topology Topology
+	// ClockPids is the clock index of the run, events and null messages
+	// carry compact clocks when it is not empty
+	ClockPids []string
+	// Durations is the distribution of the firing durations, every segment
+	// draws them from a stream derived from Seed
+	Durations  DurationDistribution
+	Seed       int64
+	Statistics StatisticsConfig
 }
 
 type PrepareSimulationResponse struct {
 	communicator.Response
 }
 
+// StartSimulationRequest starts every segment prepared on a node
 type StartSimulationRequest struct {
 	communicator.Request
+	Run RunId
 	End Clock
 }
 
@@ -27,6 +42,12 @@ type StartSimulationResponse struct {
 
 type EventRequest struct {
 	communicator.Request
+	Run RunId
+	// Source and Destination are the sending and receiving segments
+	Source      string
+	Destination string
+	// Seq numbers the messages of the link
+	Seq   uint64
 	Event Event
 }
 
@@ -36,9 +57,100 @@ type EventResponse struct {
 
 type NullMessageRequest struct {
 	communicator.Request
+	Run RunId
+	// Source and Destination are the sending and receiving segments
+	Source      string
+	Destination string
+	// Seq numbers the messages of the link
+	Seq         uint64
 	NullMessage NullMessage
 }
 
 type NullMessageResponse struct {
 	communicator.Response
 }
+
+type AbortSimulationRequest struct {
+	communicator.Request
+	Run     RunId
+	Reason  AbortReason
+	Message string
+}
+
+type AbortSimulationResponse struct {
+	communicator.Response
+}
+
+type NodeFailureRequest struct {
+	communicator.Request
+	Run         RunId
+	Source      string
+	Destination string
+	// Error keeps the code of the failure, such as the one returned by the
+	// other end of the link
+	Error *communicator.Error
+}
+
+type NodeFailureResponse struct {
+	communicator.Response
+}
+
+// StatusRequest reports a run of the node, or every run without run id
+type StatusRequest struct {
+	communicator.Request
+	Run RunId
+}
+
+type StatusResponse struct {
+	communicator.Response
+	Status NodeStatus
+}
+
+type TerminationProbeRequest struct {
+	communicator.Request
+	Run  RunId
+	Wave int
+}
+
+type TerminationProbeResponse struct {
+	communicator.Response
+	Wave     int
+	Passive  bool
+	Sent     uint64
+	Received uint64
+}
+
+// NodeCapabilities describes the resources a node offers to the runs placed
+// on it
+type NodeCapabilities struct {
+	Hostname string
+	CPUs     int
+	Daemon   bool
+	// MetricsAddress is the metrics endpoint of the node, none when empty
+	MetricsAddress string
+}
+
+// RegisterNodeRequest announces a node started with -register to the
+// launcher once it is listening. Address is empty when the node listens on
+// every interface.
+type RegisterNodeRequest struct {
+	communicator.Request
+	Node         string
+	Address      string
+	Port         string
+	Capabilities NodeCapabilities
+}
+
+type RegisterNodeResponse struct {
+	communicator.Response
+	Run RunId
+}
+
+type TerminateRequest struct {
+	communicator.Request
+	Run RunId
+}
+
+type TerminateResponse struct {
+	communicator.Response
+}
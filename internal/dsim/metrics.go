@@ -0,0 +1,108 @@
+package dsim
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/metrics"
+)
+
+// Message types of the link metrics
+const (
+	metricEvent       = "event"
+	metricNullMessage = "null"
+)
+
+// nodeMetrics are the metrics of a simulation node. The link metrics are
+// counted as messages flow, the engine metrics are read from the status
+// snapshots of the engines on every scrape.
+type nodeMetrics struct {
+	registry  *metrics.Registry
+	sent      *metrics.Counter
+	received  *metrics.Counter
+	roundTrip *metrics.Histogram
+}
+
+func newNodeMetrics(sn *SimulationNode) *nodeMetrics {
+	m := &nodeMetrics{
+		registry: metrics.NewRegistry(),
+		sent: metrics.NewCounter("dsim_link_messages_sent_total",
+			"Messages sent on the links of the hosted segments.", "run", "source", "destination", "type"),
+		received: metrics.NewCounter("dsim_link_messages_received_total",
+			"Messages received on the links of the hosted segments.", "run", "source", "destination", "type"),
+		roundTrip: metrics.NewHistogram("dsim_link_round_trip_seconds",
+			"Round-trip latency of the messages sent to other nodes, retries included.",
+			metrics.DefaultLatencyBuckets, "run", "source", "destination", "type"),
+	}
+	m.registry.Register(m.sent, m.received, m.roundTrip)
+	m.registry.RegisterCollector(func() []metrics.Metric {
+		return engineMetrics(sn.Status(""))
+	})
+	return m
+}
+
+// messageSent counts a message of the given type delivered on a link, with
+// its round-trip latency when it was sent to another node
+func (m *nodeMetrics) messageSent(run RunId, source, destination, kind string, roundTrip time.Duration) {
+	m.sent.Inc(string(run), source, destination, kind)
+	if roundTrip > 0 {
+		m.roundTrip.Observe(roundTrip.Seconds(), string(run), source, destination, kind)
+	}
+}
+
+func (m *nodeMetrics) messageReceived(run RunId, source, destination, kind string) {
+	m.received.Inc(string(run), source, destination, kind)
+}
+
+// handler serves the metrics to Prometheus scrapes
+func (m *nodeMetrics) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.registry)
+	return mux
+}
+
+// engineMetrics builds the engine and queue metrics of a node status
+func engineMetrics(status NodeStatus) []metrics.Metric {
+	var (
+		events = metrics.NewCounter("dsim_events_processed_total",
+			"Events processed by the engine of a segment.", "run", "segment")
+		fired = metrics.NewCounter("dsim_transitions_fired_total",
+			"Transitions fired by the engine of a segment.", "run", "segment")
+		blocked = metrics.NewCounter("dsim_blocked_seconds_total",
+			"Time the engine of a segment waited for the segments it depends on.", "run", "segment")
+		simClock = metrics.NewGauge("dsim_simulated_clock",
+			"Simulated clock of the engine of a segment.", "run", "segment")
+		eventList = metrics.NewGauge("dsim_event_list_length",
+			"Events pending in the local and external event lists of a segment.", "run", "segment", "list")
+		queued = metrics.NewGauge("dsim_link_queued_events",
+			"Events received from a segment and not yet inserted in the event list.", "run", "segment", "source")
+		outgoing = metrics.NewGauge("dsim_outgoing_queue_length",
+			"Messages waiting to be sent by the hosted segments of a run.", "run")
+		finished = metrics.NewGauge("dsim_run_finished",
+			"Whether the engines of a run have finished.", "run")
+	)
+	for _, run := range status.Runs {
+		id := string(run.Run)
+		outgoing.Set(float64(run.OutgoingQueue), id)
+		finished.Set(boolGauge(run.Finished), id)
+		for _, e := range run.Engines {
+			events.Add(float64(e.EventsProcessed), id, e.Segment)
+			fired.Add(float64(e.TransitionsFired), id, e.Segment)
+			blocked.Add(e.BlockedTime.Seconds(), id, e.Segment)
+			simClock.Set(float64(e.Clock), id, e.Segment)
+			eventList.Set(float64(e.EventList), id, e.Segment, "local")
+			eventList.Set(float64(e.ExternalEventList), id, e.Segment, "external")
+			for _, s := range e.Segments {
+				queued.Set(float64(s.QueuedEvents), id, e.Segment, s.Name)
+			}
+		}
+	}
+	return []metrics.Metric{events, fired, blocked, simClock, eventList, queued, outgoing, finished}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
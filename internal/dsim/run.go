@@ -0,0 +1,673 @@
+package dsim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
+)
+
+// RunId identifies a simulation run. Every message carries the id of the run
+// it belongs to, so a node can take part in several runs at the same time.
+type RunId string
+
+// simulationRun holds the segments a node simulates for one run together
+// with the state of the run: its start, termination and abort.
+type simulationRun struct {
+	id                   RunId
+	node                 *SimulationNode
+	launcherAddress      string
+	segmentsMutex        sync.RWMutex
+	segments             map[string]*hostedSegment
+	finished             chan struct{}
+	outgoingDone         chan struct{}
+	done                 chan struct{}
+	terminate            chan struct{}
+	terminateOnce        sync.Once
+	started              atomic.Bool
+	abort                chan struct{}
+	abortOnce            sync.Once
+	abortReason          AbortReason
+	linkErrors           chan error
+	eventsSent           atomic.Uint64
+	eventsReceived       atomic.Uint64
+	nullMessagesSent     atomic.Uint64
+	nullMessagesReceived atomic.Uint64
+	localMessages        atomic.Uint64
+	// clockIndex encodes the clocks of events and null messages, full
+	// clocks are sent when nil
+	clockIndex *clock.ClockIndex
+}
+
+// hostedSegment is a segment simulated by the node with the queue of the
+// messages its engine sends to other segments
+type hostedSegment struct {
+	name                  string
+	engine                *SimulationEngine
+	externalMessagesQueue chan externalMessage
+	outgoingDone          chan struct{}
+	// clockEncoders and clockDecoders are keyed by the destination and
+	// source segments of the links using compact clocks
+	clockEncoders map[string]*clock.ClockEncoder
+	clockDecoders map[string]*clock.ClockDecoder
+	// seqs numbers the messages sent to every destination segment, it is
+	// only used by the goroutine sending them
+	seqs map[string]uint64
+	// localLinks are keyed by the destination segments hosted by this node,
+	// they are only used by the goroutine sending the messages
+	localLinks map[string]*localLink
+}
+
+// localLink hands the messages of a segment to another segment hosted by the
+// same node. The queue is unbounded, so a sender never waits on the engine
+// it delivers to and co-located segments cannot deadlock on their full event
+// queues, and it keeps the order of the messages as a connection does.
+type localLink struct {
+	mutex   sync.Mutex
+	pending []interface{}
+	ready   chan struct{}
+}
+
+func newLocalLink() *localLink {
+	return &localLink{ready: make(chan struct{}, 1)}
+}
+
+// push queues a message without blocking
+func (l *localLink) push(payload interface{}) {
+	l.mutex.Lock()
+	l.pending = append(l.pending, payload)
+	l.mutex.Unlock()
+	select {
+	case l.ready <- struct{}{}:
+	default:
+	}
+}
+
+// forward delivers the queued messages from segment source to destination
+// until its engine is done or aborted
+func (l *localLink) forward(source string, destination *SimulationEngine) {
+	for {
+		select {
+		case <-l.ready:
+		case <-destination.done:
+			return
+		case <-destination.abort:
+			return
+		}
+		l.mutex.Lock()
+		pending := l.pending
+		l.pending = nil
+		l.mutex.Unlock()
+		for _, payload := range pending {
+			switch mt := payload.(type) {
+			case Event:
+				destination.deliverEvent(source, mt)
+			case NullMessage:
+				destination.nullMessageFromSegment(source, mt.Lookahead)
+			}
+		}
+	}
+}
+
+// LinkError reports a failure sending a message from one segment to another
+type LinkError struct {
+	Source      string
+	Destination string
+	Err         error
+}
+
+func (e *LinkError) Error() string {
+	return fmt.Sprintf("link %s -> %s: %v", e.Source, e.Destination, e.Err)
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+func newSimulationRun(id RunId, node *SimulationNode, launcherAddress string) *simulationRun {
+	run := &simulationRun{
+		id:              id,
+		node:            node,
+		launcherAddress: launcherAddress,
+		segments:        make(map[string]*hostedSegment),
+		finished:        make(chan struct{}),
+		outgoingDone:    make(chan struct{}),
+		done:            make(chan struct{}),
+		terminate:       make(chan struct{}),
+		abort:           make(chan struct{}),
+		linkErrors:      make(chan error, 1),
+	}
+	go run.handleLinkErrors()
+	return run
+}
+
+// supervise waits until the run terminates or is aborted and its engines
+// have flushed their results, then closes done
+func (run *simulationRun) supervise() {
+	select {
+	case <-run.finished:
+		run.node.clog.LogInfof("Run %s: simulation engines finished, waiting for global termination", run.id)
+		select {
+		case <-run.terminate:
+		case <-run.abort:
+		}
+	case <-run.abort:
+	}
+
+	if run.started.Load() {
+		// Let aborted engines flush their partial results
+		<-run.finished
+	} else {
+		// Engines never started never close their queues
+		for _, segment := range run.hostedSegments() {
+			close(segment.externalMessagesQueue)
+		}
+	}
+	close(run.done)
+}
+
+// handleExternalMessageQueue delivers the messages of a segment in order,
+// in-process when the destination segment is hosted by this node as well
+func (run *simulationRun) handleExternalMessageQueue(segment *hostedSegment) {
+	for message := range segment.externalMessagesQueue {
+		// Once aborted, just drain the queue
+		select {
+		case <-run.abort:
+			continue
+		default:
+		}
+		log.Printf("Send external message: %+v", message)
+		var err error
+		if local, ok := run.segment(message.node.Name); ok {
+			err = run.deliverLocal(segment, local, message.payload)
+		} else {
+			switch mt := message.payload.(type) {
+			case Event:
+				err = run.sendExternalEvent(segment, message.node, mt)
+			case NullMessage:
+				err = run.sendNullMessage(segment, message.node, mt)
+			}
+		}
+		if err != nil {
+			run.node.clog.LogErrorf("Run %s: send external message failed: %s", run.id, err)
+			select {
+			case run.linkErrors <- err:
+			default:
+			}
+		}
+	}
+	close(segment.outgoingDone)
+}
+
+// deliverLocal hands a message from segment source to a segment hosted by
+// this node through their local link. Local messages are never in flight, so
+// they are not accounted by termination detection.
+func (run *simulationRun) deliverLocal(segment *hostedSegment, destination *hostedSegment, payload interface{}) error {
+	source := segment.name
+	if _, ok := destination.engine.waitingOnSegments[source]; !ok {
+		return &LinkError{source, destination.name, fmt.Errorf("segment %s is not waiting on segment %s", destination.name, source)}
+	}
+	run.localMessages.Add(1)
+	kind := metricNullMessage
+	if _, ok := payload.(Event); ok {
+		kind = metricEvent
+	}
+	run.node.metrics.messageSent(run.id, source, destination.name, kind, 0)
+	run.node.metrics.messageReceived(run.id, source, destination.name, kind)
+	link, ok := segment.localLinks[destination.name]
+	if !ok {
+		link = newLocalLink()
+		segment.localLinks[destination.name] = link
+		go link.forward(source, destination.engine)
+	}
+	link.push(payload)
+	return nil
+}
+
+// segment returns the hosted segment called name
+func (run *simulationRun) segment(name string) (*hostedSegment, bool) {
+	run.segmentsMutex.RLock()
+	defer run.segmentsMutex.RUnlock()
+	segment, ok := run.segments[name]
+	return segment, ok
+}
+
+// hostedSegments returns the segments of the run sorted by name
+func (run *simulationRun) hostedSegments() []*hostedSegment {
+	run.segmentsMutex.RLock()
+	defer run.segmentsMutex.RUnlock()
+	segments := make([]*hostedSegment, 0, len(run.segments))
+	for _, segment := range run.segments {
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].name < segments[j].name })
+	return segments
+}
+
+// prepareSegment creates the engine of a segment from a prepare request
+func (run *simulationRun) prepareSegment(mt PrepareSimulationRequest) *communicator.Error {
+	run.segmentsMutex.Lock()
+	defer run.segmentsMutex.Unlock()
+	if run.started.Load() {
+		return communicator.NewError(ErrEngineAlreadyRunning, "run %s cannot prepare segment %s once started", run.id, mt.Segment).WithDetail("segment", mt.Segment)
+	}
+	if _, ok := run.segments[mt.Segment]; ok {
+		return communicator.NewError(ErrEngineAlreadyInitialized, "segment %s of run %s cannot be prepared twice", mt.Segment, run.id).WithDetail("segment", mt.Segment)
+	}
+	if err := mt.Topology.Validate(); err != nil {
+		return communicator.NewError(ErrInvalidTopology, "segment %s of run %s: %s", mt.Segment, run.id, err).WithDetail("segment", mt.Segment)
+	}
+	if _, ok := mt.Topology.Segments[mt.Segment]; !ok {
+		return communicator.NewError(ErrUnknownSegment, "segment %s is not in the topology of run %s", mt.Segment, run.id).WithDetail("segment", mt.Segment)
+	}
+
+	config := run.node.engineConfig
+	config.Segment = mt.Segment
+	config.ResultPath = runResultPath(config.ResultPath, run.id, mt.Segment)
+	if mt.Lookahead > 0 {
+		config.Lookahead = mt.Lookahead
+	}
+	config.Durations = mt.Durations
+	config.Seed = SegmentSeed(mt.Seed, mt.Segment)
+	config.Statistics = mt.Statistics
+	segment := &hostedSegment{
+		name:                  mt.Segment,
+		engine:                NewSimulationEngine(config),
+		externalMessagesQueue: make(chan externalMessage, 100),
+		outgoingDone:          make(chan struct{}),
+		seqs:                  make(map[string]uint64),
+		localLinks:            make(map[string]*localLink),
+	}
+	// Firings and blocks are only recorded for traces, they would flood the
+	// logs of every run otherwise
+	if run.node.tracer != nil || run.node.clog.HasJSONSink() {
+		segment.engine.observer = segmentObserver{run, mt.Segment}
+	}
+	if len(mt.ClockPids) > 0 {
+		if run.clockIndex == nil {
+			run.clockIndex = clock.NewClockIndex(mt.ClockPids)
+		}
+		segment.clockEncoders = make(map[string]*clock.ClockEncoder)
+		for _, destination := range mt.Topology.Notifies(mt.Segment) {
+			segment.clockEncoders[destination.Name] = run.clockIndex.NewEncoder()
+		}
+		segment.clockDecoders = make(map[string]*clock.ClockDecoder)
+		for _, source := range mt.Topology.WaitingOn(mt.Segment) {
+			segment.clockDecoders[source] = run.clockIndex.NewDecoder()
+		}
+	}
+	segment.engine.init(mt.Lefs, mt.Topology.WaitingOn(mt.Segment), mt.Topology.TransitionNodes(), mt.Topology.Notifies(mt.Segment), segment.externalMessagesQueue)
+	run.segments[mt.Segment] = segment
+	go run.handleExternalMessageQueue(segment)
+	return nil
+}
+
+// start runs the engines of every prepared segment
+func (run *simulationRun) start(end Clock) *communicator.Error {
+	run.segmentsMutex.Lock()
+	defer run.segmentsMutex.Unlock()
+	if len(run.segments) == 0 {
+		return communicator.NewError(ErrEngineNotInitialized, "run %s must be prepared before starting", run.id)
+	}
+	select {
+	case <-run.abort:
+		return communicator.NewError(ErrEngineNotInitialized, "run %s has been aborted", run.id)
+	default:
+	}
+	if !run.started.CompareAndSwap(false, true) {
+		return communicator.NewError(ErrEngineAlreadyRunning, "run %s cannot be started twice", run.id)
+	}
+	segments := make([]*hostedSegment, 0, len(run.segments))
+	for _, segment := range run.segments {
+		segments = append(segments, segment)
+		go segment.engine.simulatePeriod(0, end)
+	}
+	go func() {
+		for _, segment := range segments {
+			<-segment.engine.done
+		}
+		close(run.finished)
+		for _, segment := range segments {
+			<-segment.outgoingDone
+		}
+		close(run.outgoingDone)
+	}()
+	return nil
+}
+
+// runResultPath inserts the run id and segment name before the extension of path
+func runResultPath(path string, id RunId, segment string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%s-%s%s", strings.TrimSuffix(path, ext), id, segment, ext)
+}
+
+// checkSegment verifies messages from segment source can be delivered to the
+// engine of segment destination and returns the destination
+func (run *simulationRun) checkSegment(source, destination string) (*hostedSegment, *communicator.Error) {
+	segment, ok := run.segment(destination)
+	if !ok {
+		if run.started.Load() {
+			return nil, communicator.NewError(ErrUnknownSegment, "run %s has no segment %s on node %s", run.id, destination, run.node.pid).WithDetail("segment", destination)
+		}
+		return nil, communicator.NewError(ErrEngineNotInitialized, "segment %s of run %s received a message before being prepared", destination, run.id).WithDetail("segment", destination)
+	}
+	if _, ok := segment.engine.waitingOnSegments[source]; !ok {
+		return nil, communicator.NewError(ErrUnknownSegment, "segment %s is not waiting on segment %s", destination, source).WithDetail("segment", source)
+	}
+	return segment, nil
+}
+
+// handleLinkErrors aborts the run on the first link failure and reports it
+// to the launcher
+func (run *simulationRun) handleLinkErrors() {
+	select {
+	case err := <-run.linkErrors:
+		run.reportFailure(err)
+		run.Abort(AbortNodeFailure, err.Error())
+	case <-run.done:
+	}
+}
+
+func (run *simulationRun) reportFailure(err error) {
+	if run.launcherAddress == "" {
+		return
+	}
+	request := NodeFailureRequest{Run: run.id, Error: communicator.AsError(err)}
+	var linkError *LinkError
+	if errors.As(err, &linkError) {
+		request.Source = linkError.Source
+		request.Destination = linkError.Destination
+	}
+	clog := run.node.clog
+	cc := clog.LogErrorf("Run %s: report failure to launcher: %s", run.id, err)
+	request.Request = communicator.Request{ClockPayload: clog.Payload(cc)}
+	response, err := communicator.SendReceiveTCPTimeout(run.launcherAddress, request, abortPropagationTimeout)
+	if err != nil {
+		log.Printf("failure report to launcher failed: %s", err)
+		return
+	}
+	clog.ObserveHLC(response)
+}
+
+// Abort stops the engines of the run and propagates the abort to every other
+// node taking part in it. Only the first call has effect.
+func (run *simulationRun) Abort(reason AbortReason, message string) {
+	run.abortOnce.Do(func() {
+		run.node.clog.LogErrorf("Run %s: aborting simulation: %s: %s", run.id, reason, message)
+		run.abortReason = reason
+		for _, segment := range run.hostedSegments() {
+			segment.engine.stop()
+		}
+		run.propagateAbort(reason, message)
+		close(run.abort)
+	})
+}
+
+// AbortReason returns why the run was aborted, or AbortNone if it was not
+func (run *simulationRun) AbortReason() AbortReason {
+	select {
+	case <-run.abort:
+		return run.abortReason
+	default:
+		return AbortNone
+	}
+}
+
+func (run *simulationRun) propagateAbort(reason AbortReason, message string) {
+	peers := make(map[string]TransitionNode)
+	for _, segment := range run.hostedSegments() {
+		for _, node := range segment.engine.transitionNodes {
+			if node.Node != run.node.pid {
+				peers[node.Node] = node
+			}
+		}
+	}
+
+	clog := run.node.clog
+	var wg sync.WaitGroup
+	for _, node := range peers {
+		wg.Add(1)
+		go func(node TransitionNode) {
+			defer wg.Done()
+			address := net.JoinHostPort(node.Address, node.Port)
+			cc := clog.LogInfof("Run %s: send abort simulation request to %s", run.id, node.Node)
+			response, err := communicator.SendReceiveTCPTimeout(
+				address,
+				AbortSimulationRequest{
+					Request: communicator.Request{ClockPayload: clog.Payload(cc)},
+					Run:     run.id,
+					Reason:  reason,
+					Message: message,
+				}, abortPropagationTimeout)
+			if err != nil {
+				log.Printf("abort propagation to %s failed: %s", node.Node, err)
+				return
+			}
+			clog.ObserveHLC(response)
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (run *simulationRun) sendExternalEvent(segment *hostedSegment, node TransitionNode, event Event) error {
+	// Prepare event request
+	address := net.JoinHostPort(node.Address, node.Port)
+	source := segment.name
+	clog := run.node.clog
+	activity := segment.sendActivity(run.id, node.Name, metricEvent, event.Clock)
+	fields := activityFields(activity)
+	fields[clock.FieldEvent] = event
+	cc := clog.LogFieldsf(clock.INFO, nil, fields, "Send event to %s: %+v", node.Name, event)
+	response, err := communicator.SendReceiveTCPRetry(
+		address,
+		EventRequest{
+			Request:     segment.request(clog.Payload(cc), node.Name),
+			Run:         run.id,
+			Source:      source,
+			Destination: node.Name,
+			Seq:         activity.Seq,
+			Event:       event,
+		}, run.node.retryPolicy)
+	if err != nil {
+		return &LinkError{source, node.Name, fmt.Errorf("send event: %w", err)}
+	}
+	clog.ObserveHLC(response)
+	switch mt := response.(type) {
+	case EventResponse:
+		if mt.Error != nil {
+			return &LinkError{source, node.Name, fmt.Errorf("received unsucessful response: %w", mt.Error)}
+		}
+		log.Printf("Received success from %v", node.Name)
+	default:
+		return &LinkError{source, node.Name, fmt.Errorf("received unknown response: %+v", mt)}
+	}
+	run.eventsSent.Add(1)
+	run.messageSent(activity)
+	return nil
+}
+
+func (run *simulationRun) sendNullMessage(segment *hostedSegment, node TransitionNode, nullMessage NullMessage) error {
+	// Prepare null message request
+	address := net.JoinHostPort(node.Address, node.Port)
+	source := segment.name
+	clog := run.node.clog
+	activity := segment.sendActivity(run.id, node.Name, metricNullMessage, nullMessage.Lookahead)
+	cc := clog.LogFieldsf(clock.INFO, nil, activityFields(activity), "Send null message to %s: %+v", node.Name, nullMessage)
+	response, err := communicator.SendReceiveTCPRetry(
+		address,
+		NullMessageRequest{
+			Request:     segment.request(clog.Payload(cc), node.Name),
+			Run:         run.id,
+			Source:      source,
+			Destination: node.Name,
+			Seq:         activity.Seq,
+			NullMessage: nullMessage,
+		}, run.node.retryPolicy)
+	if err != nil {
+		return &LinkError{source, node.Name, fmt.Errorf("send null message: %w", err)}
+	}
+	clog.ObserveHLC(response)
+	switch mt := response.(type) {
+	case NullMessageResponse:
+		if mt.Error != nil {
+			return &LinkError{source, node.Name, fmt.Errorf("received unsucessful response: %w", mt.Error)}
+		}
+		log.Printf("Received success from %v", node.Name)
+	default:
+		return &LinkError{source, node.Name, fmt.Errorf("received unknown response: %+v", mt)}
+	}
+	run.nullMessagesSent.Add(1)
+	run.messageSent(activity)
+	return nil
+}
+
+// status returns a snapshot of the run and its simulation engines
+func (run *simulationRun) status() RunStatus {
+	status := RunStatus{
+		Run:                  run.id,
+		AbortReason:          run.AbortReason(),
+		EventsSent:           run.eventsSent.Load(),
+		EventsReceived:       run.eventsReceived.Load(),
+		NullMessagesSent:     run.nullMessagesSent.Load(),
+		NullMessagesReceived: run.nullMessagesReceived.Load(),
+		LocalMessages:        run.localMessages.Load(),
+	}
+	select {
+	case <-run.finished:
+		status.Finished = true
+	default:
+	}
+	for _, segment := range run.hostedSegments() {
+		status.Engines = append(status.Engines, segment.engine.status())
+		status.OutgoingQueue += len(segment.externalMessagesQueue)
+	}
+	return status
+}
+
+// request returns the request of a message to segment destination, with its
+// clock encoded when the run uses compact clocks
+func (segment *hostedSegment) request(payload clock.ClockPayload, destination string) communicator.Request {
+	if encoder, ok := segment.clockEncoders[destination]; ok {
+		payload.Compact = encoder.Encode(payload.Clock)
+		payload.Clock = nil
+	}
+	return communicator.Request{ClockPayload: payload}
+}
+
+// receivedClock returns the clock of a message from segment source to
+// segment destination of run id, decoding it when it travels compact
+func (sn *SimulationNode) receivedClock(id RunId, source, destination string, payload clock.ClockPayload) clock.ClockMap {
+	if payload.Compact == nil {
+		return payload.Clock
+	}
+	run, err := sn.lookupRun(id)
+	if err != nil {
+		return nil
+	}
+	segment, ok := run.segment(destination)
+	if !ok {
+		return nil
+	}
+	decoder, ok := segment.clockDecoders[source]
+	if !ok {
+		return nil
+	}
+	return decoder.Decode(payload.Compact)
+}
+
+// sendActivity numbers a message of the given type to segment destination
+func (segment *hostedSegment) sendActivity(run RunId, destination, message string, simClock Clock) Activity {
+	segment.seqs[destination]++
+	return Activity{
+		Kind:    ActivitySend,
+		Run:     run,
+		Segment: segment.name,
+		Peer:    destination,
+		Message: message,
+		Seq:     segment.seqs[destination],
+		Clock:   simClock,
+		Start:   time.Now(),
+	}
+}
+
+// messageSent accounts a message delivered to another node, timed from the
+// start of its send activity
+func (run *simulationRun) messageSent(activity Activity) {
+	activity.Duration = time.Since(activity.Start)
+	run.node.metrics.messageSent(run.id, activity.Segment, activity.Peer, activity.Message, activity.Duration)
+	if run.node.tracer != nil {
+		run.node.tracer.record(activity)
+	}
+}
+
+// receiveActivity is the receive of a message sent by segment source
+func receiveActivity(run RunId, source, destination, message string, seq uint64, simClock Clock) Activity {
+	return Activity{
+		Kind:    ActivityReceive,
+		Run:     run,
+		Segment: destination,
+		Peer:    source,
+		Message: message,
+		Seq:     seq,
+		Clock:   simClock,
+		Start:   time.Now(),
+	}
+}
+
+// messageReceived accounts a message accepted from another node
+func (sn *SimulationNode) messageReceived(activity Activity) {
+	sn.metrics.messageReceived(activity.Run, activity.Peer, activity.Segment, activity.Message)
+	if sn.tracer != nil {
+		sn.tracer.record(activity)
+	}
+}
+
+// segmentObserver logs and traces the activity of the engine of a segment,
+// for the tracer or the JSON log of the node
+type segmentObserver struct {
+	run     *simulationRun
+	segment string
+}
+
+func (o segmentObserver) transitionFired(result TransitionResult) {
+	a := Activity{
+		Kind:       ActivityFire,
+		Run:        o.run.id,
+		Segment:    o.segment,
+		Transition: result.TransitionId,
+		Clock:      result.ClockTriggerValue,
+		Start:      time.Now(),
+	}
+	o.run.node.clog.LogFieldsf(clock.DEBUG, nil, activityFields(a), "Fired transition %d at %v", a.Transition, a.Clock)
+	if o.run.node.tracer != nil {
+		o.run.node.tracer.record(a)
+	}
+}
+
+func (o segmentObserver) blocked(on string, since time.Time, simClock Clock) {
+	a := Activity{
+		Kind:     ActivityBlock,
+		Run:      o.run.id,
+		Segment:  o.segment,
+		Peer:     on,
+		Clock:    simClock,
+		Start:    since,
+		Duration: time.Since(since),
+	}
+	o.run.node.clog.LogFieldsf(clock.DEBUG, nil, activityFields(a), "Blocked on %s for %s", on, a.Duration)
+	if o.run.node.tracer != nil {
+		o.run.node.tracer.record(a)
+	}
+}
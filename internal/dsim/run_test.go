@@ -0,0 +1,54 @@
+package dsim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalLinkKeepsOrderPastFullQueue(t *testing.T) {
+	link := &SegmentLink{eventQueue: make(chan Event, 100), lookahead: make(chan Clock, 1)}
+	engine := &SimulationEngine{
+		waitingOnSegments: map[string]*SegmentLink{"subred0": link},
+		done:              make(chan struct{}),
+		abort:             make(chan struct{}),
+	}
+	local := newLocalLink()
+	forwarded := make(chan struct{})
+	go func() {
+		local.forward("subred0", engine)
+		close(forwarded)
+	}()
+
+	// The sender never waits on the engine, even with its queue full
+	const events = 250
+	for i := 0; i < events; i++ {
+		local.push(Event{Clock: Clock(i), Destination: 1, Value: 1})
+	}
+	local.push(NullMessage{Lookahead: events})
+
+	for i := 0; i < events; i++ {
+		select {
+		case event := <-link.eventQueue:
+			if event.Clock != Clock(i) {
+				t.Fatalf("Event %d has clock %v", i, event.Clock)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Event %d was not delivered", i)
+		}
+	}
+	select {
+	case lookahead := <-link.lookahead:
+		if lookahead != events {
+			t.Fatalf("Lookahead = %v, want %v", lookahead, events)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Null message was not delivered")
+	}
+
+	close(engine.done)
+	select {
+	case <-forwarded:
+	case <-time.After(time.Second):
+		t.Fatalf("Link kept forwarding once the engine was done")
+	}
+}
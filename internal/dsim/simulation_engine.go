@@ -2,7 +2,11 @@ package dsim
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"os"
+	"sync"
 	"time"
 )
 
@@ -20,16 +24,34 @@ type TransitionResult struct {
 }
 
 type SimulationEngineConfig struct {
+	// Segment is the name of the subnet simulated by the engine
+	Segment    string
 	Lookahead  Clock
 	ResultPath string
+	// Durations is the distribution of the firing durations, drawn from a
+	// stream seeded with Seed
+	Durations  DurationDistribution
+	Seed       int64
+	Statistics StatisticsConfig
 }
 
+// TransitionNode locates the segment owning a transition
 type TransitionNode struct {
-	Name    string
+	// Name is the segment name
+	Name string
+	// Node is the name of the simulation node hosting the segment
+	Node    string
 	Address string
 	Port    string
 }
 
+// engineObserver is told of the activity of an engine by the goroutine
+// running it
+type engineObserver interface {
+	transitionFired(result TransitionResult)
+	blocked(on string, since time.Time, clock Clock)
+}
+
 type SegmentLink struct {
 	clock      Clock
 	eventQueue chan Event
@@ -39,6 +61,7 @@ type SegmentLink struct {
 
 // SimulationEngine is the basic data type for simulation execution
 type SimulationEngine struct {
+	segment               string
 	clock                 Clock // Valor de mi reloj local
 	lookahead             Clock
 	lefs                  Lefs // Estructura de datos del simulador
@@ -52,15 +75,65 @@ type SimulationEngine struct {
 	initialized           bool
 	running               bool
 	externalMessagesQueue chan<- externalMessage
+	resultPath            string
+	end                   Clock
+	statusSnapshot        engineStatus
+	elapsedTime           time.Duration
+	blockedTime           time.Duration // tiempo esperando a otros segmentos
+	durations             DurationDistribution
+	rng                   *rand.Rand
+	statisticsConfig      StatisticsConfig
+	stats                 *statisticsCollector
+	statistics            *SegmentStatistics // medidas del periodo simulado
+	observer              engineObserver
 	done                  chan struct{}
+	abort                 chan struct{}
+	abortOnce             sync.Once
 }
 
 func NewSimulationEngine(sec SimulationEngineConfig) *SimulationEngine {
-	return &SimulationEngine{
-		lookahead:   sec.Lookahead,
-		initialized: false,
-		running:     false,
-		done:        make(chan struct{}),
+	se := &SimulationEngine{
+		segment:          sec.Segment,
+		lookahead:        sec.Lookahead,
+		resultPath:       sec.ResultPath,
+		durations:        sec.Durations,
+		statisticsConfig: sec.Statistics,
+		initialized:      false,
+		running:          false,
+		done:             make(chan struct{}),
+		abort:            make(chan struct{}),
+	}
+	if sec.Durations.Stochastic() {
+		se.rng = rand.New(rand.NewSource(sec.Seed))
+	}
+	return se
+}
+
+// stop asks a running simulation to finish as soon as possible. It is safe to
+// call it several times and before the simulation has started.
+func (se *SimulationEngine) stop() {
+	se.abortOnce.Do(func() {
+		close(se.abort)
+	})
+}
+
+func (se *SimulationEngine) aborted() bool {
+	select {
+	case <-se.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueueExternalMessage hands a message to the node unless the simulation
+// has been aborted meanwhile.
+func (se *SimulationEngine) enqueueExternalMessage(message externalMessage) bool {
+	select {
+	case se.externalMessagesQueue <- message:
+		return true
+	case <-se.abort:
+		return false
 	}
 }
 
@@ -81,10 +154,17 @@ func (se *SimulationEngine) init(lefs Lefs, waitingOnSegments []string, transiti
 	log.Println("Initialized simulation engine")
 	log.Printf("%+v", se)
 	se.initialized = true
+	se.publishStatus("")
 }
 
-func (se *SimulationEngine) eventFromSegment(id string) chan<- Event {
-	return se.waitingOnSegments[id].eventQueue
+// deliverEvent queues an event received from segment id. Events arriving once
+// the simulation is over are discarded.
+func (se *SimulationEngine) deliverEvent(id string, event Event) {
+	select {
+	case se.waitingOnSegments[id].eventQueue <- event:
+	case <-se.done:
+	case <-se.abort:
+	}
 }
 
 func (se *SimulationEngine) nullMessageFromSegment(id string, lookahead Clock) {
@@ -100,6 +180,9 @@ func (se *SimulationEngine) fireTransition(tId TransitionId) {
 	// Prepare 5 local variables
 	tl := se.lefs.Network
 	t := tl[tId]
+	t.Fired++
+	duration := se.durations.sample(se.rng, t.Duration)
+	se.stats.fired(tId, se.clock, duration)
 
 	// First apply Iul propagations (Inmediate : 0 propagation time)
 	for _, trCo := range t.Update {
@@ -108,12 +191,12 @@ func (se *SimulationEngine) fireTransition(tId TransitionId) {
 
 	for _, trCo := range t.Propagate {
 		if trCo.TransitionId < 0 {
-			se.externalEventList.insert(Event{t.Clock + t.Duration,
+			se.externalEventList.insert(Event{t.Clock + duration,
 				trCo.TransitionId,
 				trCo.Constant})
 		} else {
 			// tiempo = tiempo de la transicion + coste disparo
-			se.eventList.insert(Event{t.Clock + t.Duration,
+			se.eventList.insert(Event{t.Clock + duration,
 				trCo.TransitionId,
 				trCo.Constant})
 		}
@@ -124,8 +207,11 @@ func (se *SimulationEngine) fireEnabledTransitions() {
 	for !se.lefs.Sensitized.isEmpty() { //while
 		tId := se.lefs.getSensitized()
 		se.fireTransition(tId)
-		se.transitionResults = append(se.transitionResults,
-			TransitionResult{tId, se.clock})
+		result := TransitionResult{tId, se.clock}
+		se.transitionResults = append(se.transitionResults, result)
+		if se.observer != nil {
+			se.observer.transitionFired(result)
+		}
 	}
 }
 
@@ -145,13 +231,22 @@ func (se *SimulationEngine) forwardTime() Clock {
 	}
 
 	// Wait for the lowest clock segments wither by event, or by lookahead
-	for _, v := range se.waitingOnSegments {
+	for name, v := range se.waitingOnSegments {
 		if v.clock == lowerBoundClock {
+			se.publishStatus(name)
+			blockedSince := time.Now()
 			select {
 			case clock := <-v.lookahead:
 				v.clock = clock
 			case event := <-v.eventQueue:
 				se.eventList.insert(event)
+			case <-se.abort:
+				se.blockedTime += time.Since(blockedSince)
+				return se.clock
+			}
+			se.blockedTime += time.Since(blockedSince)
+			if se.observer != nil {
+				se.observer.blocked(name, blockedSince, se.clock)
 			}
 		}
 	Loop:
@@ -201,11 +296,21 @@ func (se *SimulationEngine) simulateStep(End Clock) {
 
 	// advance local clock to soonest available event
 	se.clock = se.forwardTime()
+	if se.aborted() {
+		return
+	}
+	// The net state is constant until the events of the new clock are handled
+	if se.clock < End {
+		se.stats.advance(se.lefs.Network, se.clock)
+	} else {
+		se.stats.advance(se.lefs.Network, End)
+	}
 
 	log.Printf("Clock: %v", se.clock)
 
 	// if events exist for current local clock, process them
 	se.handleEvents()
+	se.publishStatus("")
 }
 
 func (se *SimulationEngine) sendExternalEvents() {
@@ -221,12 +326,16 @@ func (se *SimulationEngine) sendExternalEvents() {
 		node := se.getTransitionNode(event.Destination)
 		log.Printf("%+v", node)
 		delete(notificationSegments, node.Name)
-		se.externalMessagesQueue <- externalMessage{node, event}
+		if !se.enqueueExternalMessage(externalMessage{node, event}) {
+			return
+		}
 	}
 
 	// Send null messages to not evented nodes
 	for _, node := range notificationSegments {
-		se.externalMessagesQueue <- externalMessage{node, NullMessage{Lookahead: se.clock + se.lookahead}}
+		if !se.enqueueExternalMessage(externalMessage{node, NullMessage{Lookahead: se.clock + se.lookahead}}) {
+			return
+		}
 	}
 }
 
@@ -249,25 +358,59 @@ func (se *SimulationEngine) simulatePeriod(Start Clock, End Clock) {
 	// Inicializamos el reloj local
 	// ------------------------------------------------------------------
 	se.clock = Start
+	se.end = End
+	se.stats = newStatisticsCollector(Start, End, se.statisticsConfig, se.lefs.Network)
+	se.publishStatus("")
 
-	for se.clock < End {
+	for se.clock < End && !se.aborted() {
 		se.simulateStep(End)
 	}
 
-	elapsedTime := time.Since(begin)
-
-	fmt.Printf("Eventos por segundo = %f",
-		se.eventNumber/elapsedTime.Seconds())
+	se.elapsedTime = time.Since(begin)
+	aborted := se.aborted()
+	se.statistics = se.stats.statistics(se.segment)
+	se.flushResults(aborted)
 
-	fmt.Printf("Transition results\n")
-	fmt.Printf("==================\n")
-	for _, tr := range se.transitionResults {
-		fmt.Printf("%+v\n", tr)
-	}
 	se.running = false
-	for _, node := range se.notificationSegments {
-		se.externalMessagesQueue <- externalMessage{node, NullMessage{Lookahead: End + se.lookahead}}
+	se.publishStatus("")
+	if !aborted {
+		for _, node := range se.notificationSegments {
+			if !se.enqueueExternalMessage(externalMessage{node, NullMessage{Lookahead: End + se.lookahead}}) {
+				break
+			}
+		}
 	}
 	close(se.externalMessagesQueue)
 	close(se.done)
 }
+
+// flushResults prints the transition results to stdout and, if a result path
+// was configured, writes them to that file as well. Partial results are
+// flushed when the simulation has been aborted.
+func (se *SimulationEngine) flushResults(aborted bool) {
+	writers := []io.Writer{os.Stdout}
+	if se.resultPath != "" {
+		if f, err := os.Create(se.resultPath); err != nil {
+			log.Printf("Failed to create result file %s: %v", se.resultPath, err)
+		} else {
+			defer f.Close()
+			writers = append(writers, f)
+		}
+	}
+	w := io.MultiWriter(writers...)
+
+	if aborted {
+		fmt.Fprintf(w, "Simulation aborted at clock %v, results are partial\n", se.clock)
+	}
+	fmt.Fprintf(w, "Eventos por segundo = %f\n",
+		se.eventNumber/se.elapsedTime.Seconds())
+
+	fmt.Fprintf(w, "Transition results\n")
+	fmt.Fprintf(w, "==================\n")
+	for _, tr := range se.transitionResults {
+		fmt.Fprintf(w, "%+v\n", tr)
+	}
+
+	fmt.Fprintf(w, "\n")
+	se.statistics.Print(w)
+}
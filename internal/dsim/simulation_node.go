@@ -3,11 +3,15 @@ package dsim
 import (
 	"context"
 	"encoding/gob"
-	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
 	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/communicator"
@@ -26,38 +30,87 @@ func init() {
 	gob.Register(EventResponse{})
 	gob.Register(NullMessageRequest{})
 	gob.Register(NullMessageResponse{})
+	gob.Register(AbortSimulationRequest{})
+	gob.Register(AbortSimulationResponse{})
+	gob.Register(NodeFailureRequest{})
+	gob.Register(NodeFailureResponse{})
+	gob.Register(StatusRequest{})
+	gob.Register(StatusResponse{})
+	gob.Register(TerminationProbeRequest{})
+	gob.Register(TerminationProbeResponse{})
+	gob.Register(TerminateRequest{})
+	gob.Register(TerminateResponse{})
+	gob.Register(RegisterNodeRequest{})
+	gob.Register(RegisterNodeResponse{})
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 }
 
 type SimulationNodeConfig struct {
 	ListenAddress          string
+	RetryPolicy            communicator.RetryPolicy
 	ClockLogConfig         clock.ClockLogConfig
 	SimulationEngineConfig SimulationEngineConfig
+	// Daemon keeps the node serving runs until it is interrupted, otherwise
+	// it exits once its first run has terminated or been aborted
+	Daemon bool
+	// RegisterAddress is the launcher the node announces itself to once it
+	// is listening, none when empty
+	RegisterAddress string
+	// MetricsAddress is the address of the Prometheus metrics endpoint of
+	// the node, served at /metrics, none when empty
+	MetricsAddress string
+	// TraceFilename receives a Chrome trace of the activity of the hosted
+	// segments when not empty
+	TraceFilename string
 }
 
+// SimulationNode takes part in simulation runs, hosting the engines of one or
+// more segments (subnets) of every run and exchanging their messages with
+// other nodes. Runs are independent, they are told apart by their run id.
 type SimulationNode struct {
-	pid                   string
-	listenAddress         string
-	listener              net.Listener
-	done                  chan struct{}
-	wg                    sync.WaitGroup
-	clog                  *clock.ClockLogger
-	simulationEngine      *SimulationEngine
-	externalMessagesQueue chan externalMessage
-	runningNodes          sync.WaitGroup
-	simulationEnds        Clock
+	pid           string
+	listenAddress string
+	listener      net.Listener
+	done          chan struct{}
+	wg            sync.WaitGroup
+	clog          *clock.ClockLogger
+	engineConfig  SimulationEngineConfig
+	retryPolicy   communicator.RetryPolicy
+	daemon        bool
+	registerAddr  string
+	metricsAddr   string
+	metrics       *nodeMetrics
+	metricsServer *http.Server
+	traceFilename string
+	tracer        *Tracer
+	runsMutex     sync.Mutex
+	runs          map[RunId]*simulationRun
+	stop          chan struct{}
+	stopOnce      sync.Once
+	exitReason    AbortReason
 }
 
+// abortPropagationTimeout bounds the time spent notifying each peer of an abort
+const abortPropagationTimeout = 2 * time.Second
+
 func NewSimulationNode(pid string, config SimulationNodeConfig) *SimulationNode {
 
-	return &SimulationNode{
-		pid:              pid,
-		simulationEngine: NewSimulationEngine(config.SimulationEngineConfig),
-		listenAddress:    config.ListenAddress,
-		clog:             clock.NewClockLog(pid, config.ClockLogConfig),
-		done:             make(chan struct{}),
-		runningNodes:     sync.WaitGroup{},
+	sn := &SimulationNode{
+		pid:           pid,
+		engineConfig:  config.SimulationEngineConfig,
+		listenAddress: config.ListenAddress,
+		clog:          clock.NewClockLog(pid, config.ClockLogConfig),
+		done:          make(chan struct{}),
+		retryPolicy:   config.RetryPolicy,
+		daemon:        config.Daemon,
+		registerAddr:  config.RegisterAddress,
+		metricsAddr:   config.MetricsAddress,
+		traceFilename: config.TraceFilename,
+		runs:          make(map[RunId]*simulationRun),
+		stop:          make(chan struct{}),
 	}
+	sn.metrics = newNodeMetrics(sn)
+	return sn
 }
 
 func (sn *SimulationNode) Start(ctx context.Context) (net.Addr, error) {
@@ -68,23 +121,139 @@ func (sn *SimulationNode) Start(ctx context.Context) (net.Addr, error) {
 		return nil, fmt.Errorf("controller failed to start listener: %v", err)
 	}
 	sn.clog.LogInfof("Starting simulation node")
+	if sn.traceFilename != "" {
+		if sn.tracer, err = NewTracer(sn.pid, sn.traceFilename); err != nil {
+			sn.cleanup()
+			return nil, fmt.Errorf("cannot create trace file: %w", err)
+		}
+	}
+	if sn.metricsAddr != "" {
+		if err := sn.serveMetrics(); err != nil {
+			sn.cleanup()
+			return nil, err
+		}
+	}
 	go communicator.HandleConnections(sn.listener, sn.handleClient)
+	if sn.registerAddr != "" {
+		if err := sn.register(); err != nil {
+			sn.cleanup()
+			return nil, err
+		}
+	}
 	go sn.ctxHandler(ctx)
 
 	return sn.listener.Addr(), nil
 }
 
-func (sn *SimulationNode) handleExternalMessageQueue() {
-	for message := range sn.externalMessagesQueue {
-		log.Printf("Send external message: %+v", message)
-		switch mt := message.payload.(type) {
-		case Event:
-			sn.sendExternalEvent(message.node, mt)
-		case NullMessage:
-			sn.sendNullMessage(message.node, mt)
+// register announces the node endpoint and capabilities to the launcher at
+// registerAddr
+func (sn *SimulationNode) register() error {
+	host, port, err := net.SplitHostPort(sn.listener.Addr().String())
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = ""
+	}
+	hostname, _ := os.Hostname()
+	cc := sn.clog.LogInfof("Send register request to %s", sn.registerAddr)
+	response, err := communicator.SendReceiveTCPRetry(sn.registerAddr, RegisterNodeRequest{
+		Request: communicator.Request{ClockPayload: sn.clog.Payload(cc)},
+		Node:    sn.pid,
+		Address: host,
+		Port:    port,
+		Capabilities: NodeCapabilities{
+			Hostname:       hostname,
+			CPUs:           runtime.NumCPU(),
+			Daemon:         sn.daemon,
+			MetricsAddress: sn.metricsAddr,
+		},
+	}, sn.retryPolicy)
+	if err != nil {
+		return fmt.Errorf("register with launcher %s: %w", sn.registerAddr, err)
+	}
+	sn.clog.ObserveHLC(response)
+	switch mt := response.(type) {
+	case RegisterNodeResponse:
+		if mt.Error != nil {
+			return fmt.Errorf("register with launcher %s: %w", sn.registerAddr, mt.Error)
 		}
+		sn.clog.LogMergeInfof(mt.Clock, "Registered with launcher %s for run %s", sn.registerAddr, mt.Run)
+		return nil
+	case communicator.Response:
+		if mt.Error != nil {
+			return fmt.Errorf("register with launcher %s: %w", sn.registerAddr, mt.Error)
+		}
+		return fmt.Errorf("register with launcher %s: unexpected response %+v", sn.registerAddr, mt)
+	default:
+		return fmt.Errorf("register with launcher %s: unknown response %+v", sn.registerAddr, mt)
+	}
+}
+
+// prepareRun returns the run a prepare request belongs to, creating it on
+// its first prepare request
+func (sn *SimulationNode) prepareRun(id RunId, launcherAddress string) (*simulationRun, *communicator.Error) {
+	sn.runsMutex.Lock()
+	defer sn.runsMutex.Unlock()
+	if run, ok := sn.runs[id]; ok {
+		return run, nil
+	}
+	select {
+	case <-sn.stop:
+		return nil, communicator.NewError(ErrUnknownRun, "node %s is shutting down", sn.pid).WithDetail("run", string(id))
+	default:
+	}
+	if !sn.daemon && len(sn.runs) > 0 {
+		return nil, communicator.NewError(ErrUnknownRun, "node %s only serves one run, start it as a daemon to serve several", sn.pid).WithDetail("run", string(id))
+	}
+	run := newSimulationRun(id, sn, launcherAddress)
+	sn.runs[id] = run
+	sn.clog.LogInfof("Run %s registered", id)
+	go sn.serveRun(run)
+	return run, nil
+}
+
+// lookupRun returns the registered run id
+func (sn *SimulationNode) lookupRun(id RunId) (*simulationRun, *communicator.Error) {
+	sn.runsMutex.Lock()
+	defer sn.runsMutex.Unlock()
+	if run, ok := sn.runs[id]; ok {
+		return run, nil
 	}
-	sn.runningNodes.Done()
+	return nil, communicator.NewError(ErrUnknownRun, "node %s has no run %s", sn.pid, id).WithDetail("run", string(id))
+}
+
+// runList returns the registered runs sorted by id
+func (sn *SimulationNode) runList() []*simulationRun {
+	sn.runsMutex.Lock()
+	defer sn.runsMutex.Unlock()
+	runs := make([]*simulationRun, 0, len(sn.runs))
+	for _, run := range sn.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].id < runs[j].id })
+	return runs
+}
+
+// serveRun waits for a run to end. A daemon forgets the run, any other node
+// stops with the run abort reason.
+func (sn *SimulationNode) serveRun(run *simulationRun) {
+	run.supervise()
+	sn.clog.LogInfof("Run %s ended: %s", run.id, run.AbortReason())
+	if sn.daemon {
+		sn.runsMutex.Lock()
+		delete(sn.runs, run.id)
+		sn.runsMutex.Unlock()
+		return
+	}
+	sn.stopWith(run.AbortReason())
+}
+
+func (sn *SimulationNode) stopWith(reason AbortReason) {
+	sn.stopOnce.Do(func() {
+		sn.exitReason = reason
+		close(sn.stop)
+	})
 }
 
 func (sn *SimulationNode) handleClient(conn net.Conn) {
@@ -102,133 +271,215 @@ func (sn *SimulationNode) handleClient(conn net.Conn) {
 		sn.clog.LogErrorf("error decoding message: %s", err)
 		return
 	}
+	sn.clog.ObserveHLC(data)
 
 	// Switch between decoded messages
 	switch mt := data.(type) {
 	case PrepareSimulationRequest:
-		sn.clog.LogMergeInfof(mt.Clock, "Prepare simulation request received")
-		if !sn.simulationEngine.initialized {
-			sn.externalMessagesQueue = make(chan externalMessage, 100)
-			go sn.handleExternalMessageQueue()
-
-			sn.simulationEngine.init(mt.Lefs, mt.WaitingOnSegments, mt.TransitionNodes, mt.NotificationSegments, sn.externalMessagesQueue)
-			// I am a running node
-			sn.runningNodes.Add(len(mt.WaitingOnSegments) + 1)
-			communicator.Send(conn, PrepareSimulationResponse{Response: communicator.Response{}})
-		} else {
-			communicator.Send(conn, PrepareSimulationResponse{Response: communicator.Response{Error: errors.New("simulation engine already initialized")}})
+		sn.clog.LogFieldsf(clock.INFO, mt.Clock, clock.Fields{
+			clock.FieldRun:     mt.Run,
+			clock.FieldSegment: mt.Segment,
+		}, "Prepare simulation request received for run %s segment %s", mt.Run, mt.Segment)
+		run, err := sn.prepareRun(mt.Run, ResolveAddress(mt.LauncherAddress, conn.RemoteAddr()))
+		if err == nil {
+			err = run.prepareSegment(mt)
 		}
+		communicator.Send(conn, PrepareSimulationResponse{Response: sn.response(err)})
 
 	case StartSimulationRequest:
 		sn.clog.LogMergeInfof(mt.Clock, "Start simulation request received: %+v", mt)
-		if !sn.simulationEngine.initialized {
-			communicator.Send(conn, StartSimulationResponse{Response: communicator.Response{Error: errors.New("simulation engine not initialized")}})
+		run, err := sn.lookupRun(mt.Run)
+		if err == nil {
+			err = run.start(mt.End)
+		}
+		communicator.Send(conn, StartSimulationResponse{Response: sn.response(err)})
+	case EventRequest:
+		activity := receiveActivity(mt.Run, mt.Source, mt.Destination, metricEvent, mt.Seq, mt.Event.Clock)
+		fields := activityFields(activity)
+		fields[clock.FieldEvent] = mt.Event
+		sn.clog.LogFieldsf(clock.INFO, sn.receivedClock(mt.Run, mt.Source, mt.Destination, mt.ClockPayload), fields, "External event received: %+v", mt)
+		run, err := sn.lookupRun(mt.Run)
+		if err != nil {
+			communicator.Send(conn, EventResponse{Response: sn.response(err)})
 			return
 		}
-		if !sn.simulationEngine.running {
-			sn.simulationEnds = mt.End
-			go sn.simulationEngine.simulatePeriod(0, mt.End)
-			communicator.Send(conn, StartSimulationResponse{Response: communicator.Response{}})
-		} else {
-			communicator.Send(conn, StartSimulationResponse{Response: communicator.Response{Error: errors.New("simulation engine already running")}})
+		segment, err := run.checkSegment(mt.Source, mt.Destination)
+		if err != nil {
+			communicator.Send(conn, EventResponse{Response: sn.response(err)})
+			return
 		}
-	case EventRequest:
-		sn.clog.LogMergeInfof(mt.Clock, "External event received: %+v", mt)
-		communicator.Send(conn, EventResponse{Response: communicator.Response{}})
-		sn.simulationEngine.eventFromSegment(mt.Pid) <- mt.Event
+		run.eventsReceived.Add(1)
+		sn.messageReceived(activity)
+		communicator.Send(conn, EventResponse{Response: sn.response(nil)})
+		segment.engine.deliverEvent(mt.Source, mt.Event)
 		log.Printf("Enqueued event from segment")
 
 	case NullMessageRequest:
-		sn.clog.LogMergeInfof(mt.Clock, "External null message received: %+v", mt)
-		communicator.Send(conn, NullMessageResponse{Response: communicator.Response{}})
-		sn.simulationEngine.nullMessageFromSegment(mt.Pid, mt.NullMessage.Lookahead)
-		if mt.NullMessage.Lookahead > sn.simulationEnds {
-			sn.runningNodes.Done()
+		activity := receiveActivity(mt.Run, mt.Source, mt.Destination, metricNullMessage, mt.Seq, mt.NullMessage.Lookahead)
+		sn.clog.LogFieldsf(clock.INFO, sn.receivedClock(mt.Run, mt.Source, mt.Destination, mt.ClockPayload), activityFields(activity), "External null message received: %+v", mt)
+		run, err := sn.lookupRun(mt.Run)
+		if err != nil {
+			communicator.Send(conn, NullMessageResponse{Response: sn.response(err)})
+			return
+		}
+		segment, err := run.checkSegment(mt.Source, mt.Destination)
+		if err != nil {
+			communicator.Send(conn, NullMessageResponse{Response: sn.response(err)})
+			return
+		}
+		run.nullMessagesReceived.Add(1)
+		sn.messageReceived(activity)
+		communicator.Send(conn, NullMessageResponse{Response: sn.response(nil)})
+		segment.engine.nullMessageFromSegment(mt.Source, mt.NullMessage.Lookahead)
+	case StatusRequest:
+		sn.clog.LogMergeDebugf(mt.Clock, "Status request received from %s", mt.Pid)
+		communicator.Send(conn, StatusResponse{Response: sn.response(nil), Status: sn.Status(mt.Run)})
+	case TerminationProbeRequest:
+		sn.clog.LogMergeDebugf(mt.Clock, "Termination probe %d of run %s received from %s", mt.Wave, mt.Run, mt.Pid)
+		run, err := sn.lookupRun(mt.Run)
+		if err != nil {
+			communicator.Send(conn, TerminationProbeResponse{Response: sn.response(err)})
+			return
+		}
+		communicator.Send(conn, run.terminationProbe(mt.Wave))
+	case TerminateRequest:
+		sn.clog.LogMergeInfof(mt.Clock, "Terminate request of run %s received from %s", mt.Run, mt.Pid)
+		run, err := sn.lookupRun(mt.Run)
+		if err != nil {
+			communicator.Send(conn, TerminateResponse{Response: sn.response(err)})
+			return
+		}
+		if !run.passive() {
+			communicator.Send(conn, TerminateResponse{Response: sn.response(communicator.NewError(ErrNodeActive, "run %s on node %s has not finished yet", mt.Run, sn.pid))})
+			return
+		}
+		communicator.Send(conn, TerminateResponse{Response: sn.response(nil)})
+		run.terminateOnce.Do(func() {
+			close(run.terminate)
+		})
+	case AbortSimulationRequest:
+		sn.clog.LogMergeErrorf(mt.Clock, "Abort simulation request of run %s received from %s: %s: %s", mt.Run, mt.Pid, mt.Reason, mt.Message)
+		run, err := sn.lookupRun(mt.Run)
+		communicator.Send(conn, AbortSimulationResponse{Response: sn.response(err)})
+		if err == nil {
+			go run.Abort(mt.Reason, mt.Message)
 		}
 	default:
 		sn.clog.LogErrorf("%v message type received but not handled", mt)
+		communicator.Send(conn, sn.response(communicator.NewError(communicator.ErrUnhandledMessage, "%T message not handled by node %s", mt, sn.pid)))
 	}
 }
 
+// response returns a response stamped with the hybrid logical clock of the
+// node, so the receiver observes it as it does for requests
+func (sn *SimulationNode) response(err *communicator.Error) communicator.Response {
+	return communicator.Response{ClockPayload: sn.clog.ResponsePayload(), Error: err}
+}
+
 func (sn *SimulationNode) ctxHandler(ctx context.Context) {
 
-Loop:
-	for {
+	select {
+	case <-sn.stop:
+	case <-ctx.Done():
+		sn.stopWith(AbortInterrupted)
+	}
+
+	// Abort the runs still active and let them flush their partial results
+	for _, run := range sn.runList() {
 		select {
-		case <-sn.simulationEngine.done:
-			sn.clog.LogInfof("Simulation engine finished")
-			sn.runningNodes.Wait()
-			break Loop
-		case <-ctx.Done():
-			break Loop
+		case <-run.done:
+			continue
+		default:
 		}
+		run.Abort(AbortInterrupted, fmt.Sprintf("node %s is shutting down", sn.pid))
+		<-run.done
 	}
 
 	sn.cleanup()
 }
 
-func (sn *SimulationNode) sendExternalEvent(node TransitionNode, event Event) {
-	var (
-		response interface{}
-		err      error
-	)
-	// Prepare event request
-	address := net.JoinHostPort(node.Address, node.Port)
-	cc := sn.clog.LogInfof("Send event to %s: %+v", node.Name, event)
-	if response, err = communicator.SendReceiveTCP(
-		address,
-		EventRequest{
-			Request: communicator.RequestWithClock(sn.clog.GetPid(), cc),
-			Event:   event,
-		}); err != nil {
-		log.Fatalf("send event failed to receive response from %s: %s", node.Name, err)
+// AbortReason returns why the node stopped: the abort reason of its run, or
+// AbortInterrupted if it was interrupted. It is AbortNone while running.
+func (sn *SimulationNode) AbortReason() AbortReason {
+	select {
+	case <-sn.stop:
+		return sn.exitReason
+	default:
+		return AbortNone
 	}
-	switch mt := response.(type) {
-	case EventResponse:
-		if mt.Error == nil {
-			log.Printf("Received success from %v", sn.clog.GetPid())
-		} else {
-			log.Fatalf("received unsucessful response from %v: %s", sn.clog.GetPid(), mt.Error)
+}
+
+// Status returns a snapshot of the node and the engines of its runs. With a
+// run id only that run is reported.
+func (sn *SimulationNode) Status(run RunId) NodeStatus {
+	status := NodeStatus{
+		Pid:    sn.pid,
+		Daemon: sn.daemon,
+	}
+	if sn.listener != nil {
+		status.Address = sn.listener.Addr().String()
+	}
+	for _, r := range sn.runList() {
+		if run == "" || r.id == run {
+			status.Runs = append(status.Runs, r.status())
 		}
-	default:
-		log.Fatalf("Received  unknown response from controller")
 	}
+	return status
 }
 
-func (sn *SimulationNode) sendNullMessage(node TransitionNode, nullMessage NullMessage) {
-	var (
-		response interface{}
-		err      error
-	)
-	// Prepare event request
-	address := net.JoinHostPort(node.Address, node.Port)
-	cc := sn.clog.LogInfof("Send null message to %s: %+v", node.Name, nullMessage)
-	if response, err = communicator.SendReceiveTCP(
-		address,
-		NullMessageRequest{
-			Request:     communicator.RequestWithClock(sn.clog.GetPid(), cc),
-			NullMessage: nullMessage,
-		}); err != nil {
-		log.Fatalf("send null message failed to receive response from %s", node.Name)
+// ResolveAddress completes an address announced by a peer, such as the
+// launcher address of a prepare request, with the peer host when the peer
+// listens on every interface.
+func ResolveAddress(announced string, remote net.Addr) string {
+	if announced == "" {
+		return ""
 	}
-	switch mt := response.(type) {
-	case NullMessageResponse:
-		if mt.Error == nil {
-			log.Printf("Received success from %v", sn.clog.GetPid())
-		} else {
-			log.Fatalf("received unsucessful response from %v: %s", sn.clog.GetPid(), mt.Error)
+	host, port, err := net.SplitHostPort(announced)
+	if err != nil {
+		return announced
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if remoteHost, _, err := net.SplitHostPort(remote.String()); err == nil {
+			host = remoteHost
 		}
-	default:
-		log.Fatalf("Received  unknown response from controller")
 	}
+	return net.JoinHostPort(host, port)
 }
 
 func (sn *SimulationNode) Done() <-chan struct{} {
 	return sn.done
 }
 
+// serveMetrics listens on the metrics address and serves the node metrics
+// until the node is cleaned up
+func (sn *SimulationNode) serveMetrics() error {
+	listener, err := net.Listen("tcp", sn.metricsAddr)
+	if err != nil {
+		return fmt.Errorf("metrics endpoint failed to start listener: %v", err)
+	}
+	sn.metricsAddr = listener.Addr().String()
+	sn.metricsServer = &http.Server{Handler: sn.metrics.handler(), ReadHeaderTimeout: 5 * time.Second}
+	sn.clog.LogInfof("Serving metrics at http://%s/metrics", sn.metricsAddr)
+	go func() {
+		if err := sn.metricsServer.Serve(listener); err != http.ErrServerClosed {
+			log.Printf("metrics endpoint failed: %s", err)
+		}
+	}()
+	return nil
+}
+
 func (sn *SimulationNode) cleanup() {
+	if sn.listener != nil {
+		sn.listener.Close()
+	}
+	if sn.metricsServer != nil {
+		sn.metricsServer.Close()
+	}
+	if sn.tracer != nil {
+		if err := sn.tracer.Close(); err != nil {
+			log.Printf("Failed to close trace file: %v", err)
+		}
+	}
 	sn.wg.Wait()
-	sn.listener.Close()
 	close(sn.done)
 }
@@ -0,0 +1,350 @@
+package dsim
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+)
+
+// Summary holds the moments of a sample. Summaries of several samples are
+// merged exactly, with the parallel variant of Welford's algorithm.
+type Summary struct {
+	Count uint64
+	Mean  float64
+	// M2 is the sum of squared differences from the mean
+	M2  float64
+	Min float64
+	Max float64
+}
+
+// Add adds an observation to the sample
+func (s *Summary) Add(x float64) {
+	s.Merge(Summary{Count: 1, Mean: x, Min: x, Max: x})
+}
+
+// Merge adds the observations of another sample
+func (s *Summary) Merge(o Summary) {
+	if o.Count == 0 {
+		return
+	}
+	if s.Count == 0 {
+		*s = o
+		return
+	}
+	n := float64(s.Count + o.Count)
+	delta := o.Mean - s.Mean
+	s.M2 += o.M2 + delta*delta*float64(s.Count)*float64(o.Count)/n
+	s.Mean += delta * float64(o.Count) / n
+	s.Count += o.Count
+	s.Min = math.Min(s.Min, o.Min)
+	s.Max = math.Max(s.Max, o.Max)
+}
+
+// Variance returns the sample variance, 0 with less than two observations
+func (s Summary) Variance() float64 {
+	if s.Count < 2 {
+		return 0
+	}
+	return s.M2 / float64(s.Count-1)
+}
+
+// StdDev returns the sample standard deviation
+func (s Summary) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// StatisticsConfig selects the part of the simulated period measured by the
+// statistics of a segment
+type StatisticsConfig struct {
+	// WarmUp is the simulated time discarded at the start of the period
+	WarmUp Clock
+	// Batches splits the measured period into batches of equal length for a
+	// batch means analysis, none when lower than 2
+	Batches int
+}
+
+// Measures are the time averaged measures of a transition over an interval
+type Measures struct {
+	// Throughput is the number of firings per simulated time unit
+	Throughput float64
+	// MeanValue is the time average of the enabling function value
+	MeanValue float64
+	// Utilization is the fraction of the interval the transition was
+	// enabled. Enabled transitions fire at once, so a firing in progress
+	// counts as enabled.
+	Utilization float64
+}
+
+// TransitionStatistics are the performance measures of a transition over
+// the measured period
+type TransitionStatistics struct {
+	Transition TransitionId
+	Segment    string
+	Fired      uint64
+	Measures
+	// InterFiring are the simulated times between consecutive firings
+	InterFiring Summary
+	// Batches are the measures of every batch of the period
+	Batches []Measures `json:",omitempty"`
+}
+
+// SegmentStatistics are the measures of the transitions of a segment over
+// [Start, End], the simulated period after the warm-up
+type SegmentStatistics struct {
+	Segment     string
+	WarmUp      Clock
+	Start       Clock
+	End         Clock
+	Transitions []TransitionStatistics
+}
+
+// accumulator integrates the state of a transition over an interval
+type accumulator struct {
+	fired       uint64
+	valueArea   float64
+	enabledTime float64
+}
+
+func (acc accumulator) measures(length float64) Measures {
+	if length <= 0 {
+		return Measures{}
+	}
+	return Measures{
+		Throughput:  float64(acc.fired) / length,
+		MeanValue:   acc.valueArea / length,
+		Utilization: acc.enabledTime / length,
+	}
+}
+
+// transitionAccumulator integrates the state of a transition over the
+// measured period and its batches
+type transitionAccumulator struct {
+	accumulator
+	batches     []accumulator
+	lastFiring  Clock
+	busyUntil   Clock
+	interFiring Summary
+}
+
+// statisticsCollector accumulates the measures of a segment while its engine
+// runs. The state of the net is constant between clock advances.
+type statisticsCollector struct {
+	warmUp      Clock
+	start       Clock
+	last        Clock
+	batchLength Clock
+	batches     int
+	transitions map[TransitionId]*transitionAccumulator
+}
+
+// newStatisticsCollector measures the period [start, end] of network after
+// the warm-up of config
+func newStatisticsCollector(start, end Clock, config StatisticsConfig, network TransitionMap) *statisticsCollector {
+	sc := &statisticsCollector{
+		warmUp:      config.WarmUp,
+		start:       start + config.WarmUp,
+		last:        start + config.WarmUp,
+		transitions: make(map[TransitionId]*transitionAccumulator, len(network)),
+	}
+	if config.Batches > 1 && end > sc.start {
+		sc.batches = config.Batches
+		sc.batchLength = (end - sc.start) / Clock(config.Batches)
+	}
+	for id := range network {
+		sc.transitions[id] = &transitionAccumulator{batches: make([]accumulator, sc.batches)}
+	}
+	return sc
+}
+
+// batch returns the batch of a measured clock
+func (sc *statisticsCollector) batch(clock Clock) int {
+	b := int((clock - sc.start) / sc.batchLength)
+	if b >= sc.batches {
+		b = sc.batches - 1
+	}
+	return b
+}
+
+// advance integrates the state of the network from the last advance to to.
+// Nothing is measured during the warm-up.
+func (sc *statisticsCollector) advance(network TransitionMap, to Clock) {
+	if to <= sc.last {
+		return
+	}
+	// The batch is loop state: rounding may put the end of a batch at or
+	// before from, the next one is still taken so the loop ends
+	b := 0
+	if sc.batches > 0 {
+		b = sc.batch(sc.last)
+	}
+	for from := sc.last; from < to; {
+		until := to
+		if b < sc.batches-1 {
+			if end := sc.start + Clock(b+1)*sc.batchLength; end < until {
+				until = end
+			}
+		}
+		for id, t := range network {
+			acc := sc.transitions[id]
+			valueArea, enabledTime := integrate(t.Value, acc.busyUntil, from, until)
+			acc.valueArea += valueArea
+			acc.enabledTime += enabledTime
+			if sc.batches > 0 {
+				acc.batches[b].valueArea += valueArea
+				acc.batches[b].enabledTime += enabledTime
+			}
+		}
+		if until < to {
+			b++
+		}
+		from = until
+	}
+	sc.last = to
+}
+
+// integrate returns the value area and enabled time over [from, to] of a
+// transition with value, busy firing until busyUntil
+func integrate(value Const, busyUntil, from, to Clock) (valueArea, enabledTime float64) {
+	dt := float64(to - from)
+	valueArea = float64(value) * dt
+	switch {
+	case value <= 0, busyUntil >= to:
+		enabledTime = dt
+	case busyUntil > from:
+		enabledTime = float64(busyUntil - from)
+	}
+	return valueArea, enabledTime
+}
+
+// fired accounts the firing of a transition at clock, lasting duration.
+// Firings during the warm-up only make the transition busy.
+func (sc *statisticsCollector) fired(id TransitionId, clock Clock, duration Clock) {
+	acc := sc.transitions[id]
+	if clock+duration > acc.busyUntil {
+		acc.busyUntil = clock + duration
+	}
+	if clock < sc.start {
+		return
+	}
+	if acc.fired > 0 {
+		acc.interFiring.Add(float64(clock - acc.lastFiring))
+	}
+	acc.fired++
+	acc.lastFiring = clock
+	if sc.batches > 0 {
+		acc.batches[sc.batch(clock)].fired++
+	}
+}
+
+// statistics returns the measures of the segment over the period measured
+// so far
+func (sc *statisticsCollector) statistics(segment string) *SegmentStatistics {
+	stats := &SegmentStatistics{Segment: segment, WarmUp: sc.warmUp, Start: sc.start, End: sc.last}
+	if stats.End < stats.Start {
+		stats.End = stats.Start
+	}
+	for id, acc := range sc.transitions {
+		ts := TransitionStatistics{
+			Transition:  id,
+			Segment:     segment,
+			Fired:       acc.fired,
+			Measures:    acc.measures(float64(stats.End - stats.Start)),
+			InterFiring: acc.interFiring,
+		}
+		for b, batch := range acc.batches {
+			// The batches after an abort are not measured
+			from := sc.start + Clock(b)*sc.batchLength
+			if from >= sc.last {
+				break
+			}
+			until := from + sc.batchLength
+			if b == sc.batches-1 || until > sc.last {
+				until = sc.last
+			}
+			ts.Batches = append(ts.Batches, batch.measures(float64(until-from)))
+		}
+		stats.Transitions = append(stats.Transitions, ts)
+	}
+	sort.Slice(stats.Transitions, func(i, j int) bool {
+		return stats.Transitions[i].Transition < stats.Transitions[j].Transition
+	})
+	return stats
+}
+
+// RunStatistics are the measures of every transition of a run, aggregated
+// from the statistics of its segments
+type RunStatistics struct {
+	Run RunId
+	// Period is the longest period simulated by a segment
+	Period      Clock
+	Segments    int
+	Fired       uint64
+	Throughput  float64
+	Transitions []TransitionStatistics
+	// InterFiring merges the inter-firing times of every transition
+	InterFiring Summary
+}
+
+// AggregateStatistics aggregates the statistics of the segments of a run.
+// Transition ids are global, so every transition is measured by one segment.
+func AggregateStatistics(run RunId, segments []SegmentStatistics) RunStatistics {
+	stats := RunStatistics{Run: run, Segments: len(segments)}
+	for _, s := range segments {
+		if period := s.End - s.Start; period > stats.Period {
+			stats.Period = period
+		}
+		for _, ts := range s.Transitions {
+			stats.Fired += ts.Fired
+			stats.InterFiring.Merge(ts.InterFiring)
+			stats.Transitions = append(stats.Transitions, ts)
+		}
+	}
+	if stats.Period > 0 {
+		stats.Throughput = float64(stats.Fired) / float64(stats.Period)
+	}
+	sort.Slice(stats.Transitions, func(i, j int) bool {
+		return stats.Transitions[i].Transition < stats.Transitions[j].Transition
+	})
+	return stats
+}
+
+// printTransitionStatistics writes a table of transition measures
+func printTransitionStatistics(w io.Writer, transitions []TransitionStatistics) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TRANSITION\tSEGMENT\tFIRED\tTHROUGHPUT\tMEAN VALUE\tUTILIZATION\tINTER-FIRING MEAN\tSTDDEV\tMIN\tMAX")
+	for _, ts := range transitions {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%.4f\t%.4f\t%.4f\t%s\n", ts.Transition, ts.Segment, ts.Fired,
+			ts.Throughput, ts.MeanValue, ts.Utilization, formatSummary(ts.InterFiring))
+	}
+	tw.Flush()
+}
+
+func formatSummary(s Summary) string {
+	if s.Count == 0 {
+		return "-\t-\t-\t-"
+	}
+	return fmt.Sprintf("%.4f\t%.4f\t%.4f\t%.4f", s.Mean, s.StdDev(), s.Min, s.Max)
+}
+
+// Print writes the statistics of the segment
+func (s *SegmentStatistics) Print(w io.Writer) {
+	fmt.Fprintf(w, "Statistics of segment %s over [%v, %v]", s.Segment, s.Start, s.End)
+	if s.WarmUp > 0 {
+		fmt.Fprintf(w, " after a warm-up of %v", s.WarmUp)
+	}
+	fmt.Fprintln(w)
+	printTransitionStatistics(w, s.Transitions)
+}
+
+// Print writes the statistics of the run
+func (s RunStatistics) Print(w io.Writer) {
+	fmt.Fprintf(w, "Statistics of run %s: %d segments, period %v, %d firings, throughput %.4f\n",
+		s.Run, s.Segments, s.Period, s.Fired, s.Throughput)
+	if s.InterFiring.Count > 0 {
+		fmt.Fprintf(w, "Inter-firing time: mean %.4f, stddev %.4f, min %.4f, max %.4f over %d intervals\n",
+			s.InterFiring.Mean, s.InterFiring.StdDev(), s.InterFiring.Min, s.InterFiring.Max, s.InterFiring.Count)
+	}
+	printTransitionStatistics(w, s.Transitions)
+}
@@ -0,0 +1,140 @@
+package dsim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSummaryMerge(t *testing.T) {
+	samples := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	var all, left, right Summary
+	for i, x := range samples {
+		all.Add(x)
+		if i < 3 {
+			left.Add(x)
+		} else {
+			right.Add(x)
+		}
+	}
+	left.Merge(right)
+	for _, s := range []Summary{all, left} {
+		if s.Count != 8 || s.Mean != 5 || s.Min != 2 || s.Max != 9 {
+			t.Fatalf("Summary %+v", s)
+		}
+		if math.Abs(s.Variance()-32.0/7) > 1e-9 {
+			t.Fatalf("Variance = %v, want %v", s.Variance(), 32.0/7)
+		}
+	}
+}
+
+func TestStatisticsCollector(t *testing.T) {
+	network := TransitionMap{
+		0: &Transition{Id: 0, Value: 0},
+		1: &Transition{Id: 1, Value: 2},
+	}
+	sc := newStatisticsCollector(0, 10, StatisticsConfig{}, network)
+	sc.fired(0, 0, 0)
+	sc.advance(network, 4)
+	network[0].Value = 1
+	network[1].Value = 0
+	sc.fired(1, 4, 1)
+	sc.fired(1, 6, 1)
+	sc.fired(0, 4, 2)
+	sc.advance(network, 10)
+	sc.fired(1, 10, 1)
+
+	stats := sc.statistics("s")
+	if stats.Start != 0 || stats.End != 10 || len(stats.Transitions) != 2 {
+		t.Fatalf("Statistics %+v", stats)
+	}
+	t0, t1 := stats.Transitions[0], stats.Transitions[1]
+	if t0.Fired != 2 || t0.Throughput != 0.2 || t0.MeanValue != 0.6 || t0.Utilization != 0.6 || t0.InterFiring.Count != 1 {
+		t.Fatalf("Transition 0 %+v", t0)
+	}
+	if t1.Fired != 3 || t1.MeanValue != 0.8 || t1.Utilization != 0.6 {
+		t.Fatalf("Transition 1 %+v", t1)
+	}
+	if t1.InterFiring.Count != 2 || t1.InterFiring.Mean != 3 || t1.InterFiring.Min != 2 || t1.InterFiring.Max != 4 {
+		t.Fatalf("Inter-firing times %+v", t1.InterFiring)
+	}
+
+	run := AggregateStatistics("r", []SegmentStatistics{*stats, {Segment: "u", Start: 0, End: 20}})
+	if run.Period != 20 || run.Fired != 5 || run.Throughput != 0.25 || run.InterFiring.Count != 3 || len(run.Transitions) != 2 {
+		t.Fatalf("Run statistics %+v", run)
+	}
+}
+
+func TestStatisticsWarmUpAndBatches(t *testing.T) {
+	network := TransitionMap{0: &Transition{Id: 0, Value: 1}}
+	sc := newStatisticsCollector(0, 10, StatisticsConfig{WarmUp: 2, Batches: 4}, network)
+	// Busy until 3, only [2, 3] is measured
+	sc.fired(0, 1, 2)
+	sc.advance(network, 4)
+	sc.fired(0, 4, 0)
+	sc.fired(0, 5, 0)
+	network[0].Value = 0
+	sc.advance(network, 8)
+	sc.fired(0, 8, 0)
+	network[0].Value = 3
+	sc.advance(network, 10)
+
+	stats := sc.statistics("s")
+	if stats.Start != 2 || stats.End != 10 || stats.WarmUp != 2 {
+		t.Fatalf("Statistics %+v", stats)
+	}
+	ts := stats.Transitions[0]
+	if ts.Fired != 3 || ts.Throughput != 3.0/8 || ts.Utilization != 5.0/8 || ts.MeanValue != 8.0/8 {
+		t.Fatalf("Transition %+v", ts)
+	}
+	if ts.InterFiring.Count != 2 || ts.InterFiring.Mean != 2 {
+		t.Fatalf("Inter-firing times %+v", ts.InterFiring)
+	}
+	want := []Measures{
+		{Throughput: 0, MeanValue: 1, Utilization: 0.5},
+		{Throughput: 1, MeanValue: 0, Utilization: 1},
+		{Throughput: 0, MeanValue: 0, Utilization: 1},
+		{Throughput: 0.5, MeanValue: 3, Utilization: 0},
+	}
+	if len(ts.Batches) != len(want) {
+		t.Fatalf("Batches %+v", ts.Batches)
+	}
+	for i, m := range want {
+		if ts.Batches[i] != m {
+			t.Fatalf("Batch %d = %+v, want %+v", i, ts.Batches[i], m)
+		}
+	}
+}
+
+func TestStatisticsBatchBoundaries(t *testing.T) {
+	// Float32 batch boundaries used to stall the advance of these batches
+	for _, batches := range []int{9, 15, 17, 18, 19} {
+		network := TransitionMap{0: &Transition{Id: 0, Value: 1}}
+		sc := newStatisticsCollector(0, 100, StatisticsConfig{Batches: batches}, network)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for b := 1; b <= batches; b++ {
+				sc.advance(network, sc.start+Clock(b)*sc.batchLength)
+			}
+			for clock := Clock(1); clock <= 100; clock++ {
+				sc.advance(network, clock)
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Advance over %d batches does not end", batches)
+		}
+
+		ts := sc.statistics("s").Transitions[0]
+		if len(ts.Batches) != batches {
+			t.Fatalf("%d batches measured, want %d", len(ts.Batches), batches)
+		}
+		for i, m := range ts.Batches {
+			if math.Abs(m.MeanValue-1) > 1e-3 {
+				t.Fatalf("Batch %d of %d has mean value %v, want 1", i, batches, m.MeanValue)
+			}
+		}
+	}
+}
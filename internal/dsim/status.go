@@ -0,0 +1,117 @@
+package dsim
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SegmentStatus is the state of the link with a segment the engine waits on
+type SegmentStatus struct {
+	Name         string
+	Clock        Clock
+	QueuedEvents int
+}
+
+// EngineStatus is a snapshot of a simulation engine taken while it runs
+type EngineStatus struct {
+	Segment           string
+	Initialized       bool
+	Running           bool
+	Clock             Clock
+	End               Clock
+	EventList         int
+	ExternalEventList int
+	EventsProcessed   uint64
+	TransitionsFired  uint64
+	// BlockedTime is the time spent waiting for the segments it depends on
+	BlockedTime time.Duration
+	BlockedOn   string
+	Segments    []SegmentStatus
+	// Statistics are set once the engine has finished
+	Statistics *SegmentStatistics
+}
+
+// RunStatus is the state of a run on a simulation node
+type RunStatus struct {
+	Run                  RunId
+	Finished             bool
+	AbortReason          AbortReason
+	Engines              []EngineStatus
+	OutgoingQueue        int
+	EventsSent           uint64
+	EventsReceived       uint64
+	NullMessagesSent     uint64
+	NullMessagesReceived uint64
+	// LocalMessages counts the messages between segments of the node
+	LocalMessages uint64
+}
+
+// NodeStatus is returned by a simulation node on a status request
+type NodeStatus struct {
+	Pid     string
+	Address string
+	Daemon  bool
+	Runs    []RunStatus
+}
+
+// engineStatus guards the last snapshot published by the engine goroutine,
+// so status requests never touch the engine state directly.
+type engineStatus struct {
+	mutex  sync.RWMutex
+	status EngineStatus
+}
+
+func (es *engineStatus) get() EngineStatus {
+	es.mutex.RLock()
+	defer es.mutex.RUnlock()
+	status := es.status
+	status.Segments = append([]SegmentStatus(nil), es.status.Segments...)
+	return status
+}
+
+// publishStatus stores a snapshot of the engine state. It must be called from
+// the goroutine running the simulation.
+func (se *SimulationEngine) publishStatus(blockedOn string) {
+	segments := make([]SegmentStatus, 0, len(se.waitingOnSegments))
+	for name, v := range se.waitingOnSegments {
+		segments = append(segments, SegmentStatus{
+			Name:         name,
+			Clock:        v.clock,
+			QueuedEvents: len(v.eventQueue),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Name < segments[j].Name })
+
+	se.statusSnapshot.mutex.Lock()
+	defer se.statusSnapshot.mutex.Unlock()
+	se.statusSnapshot.status = EngineStatus{
+		Segment:           se.segment,
+		Initialized:       se.initialized,
+		Running:           se.running,
+		Clock:             se.clock,
+		End:               se.end,
+		EventList:         len(se.eventList),
+		ExternalEventList: len(se.externalEventList),
+		EventsProcessed:   uint64(se.eventNumber),
+		TransitionsFired:  uint64(len(se.transitionResults)),
+		BlockedTime:       se.blockedTime,
+		BlockedOn:         blockedOn,
+		Segments:          segments,
+		Statistics:        se.statistics,
+	}
+}
+
+// status returns the last published snapshot with up to date queue lengths
+func (se *SimulationEngine) status() EngineStatus {
+	status := se.statusSnapshot.get()
+	if !status.Initialized {
+		return status
+	}
+	for i, s := range status.Segments {
+		if v, ok := se.waitingOnSegments[s.Name]; ok {
+			status.Segments[i].QueuedEvents = len(v.eventQueue)
+		}
+	}
+	return status
+}
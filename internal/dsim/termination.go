@@ -0,0 +1,81 @@
+package dsim
+
+// Termination is detected by the launcher with the four counter method
+// (Mattern, 1987), separately for every run. The launcher repeatedly probes
+// every node, which answers
+// whether it is passive and how many messages it has sent and received.
+// Once passive a node never becomes active again: messages received after
+// its engine finished are absorbed. The simulation has terminated when two
+// consecutive waves find every node passive, with the same counters in both
+// waves and as many messages received as sent, so no message is in flight.
+
+// passive reports whether the engines have finished simulating and every
+// outgoing message has been delivered. Messages between segments of the same
+// node are delivered in-process and never counted.
+func (run *simulationRun) passive() bool {
+	select {
+	case <-run.finished:
+	default:
+		return false
+	}
+	select {
+	case <-run.outgoingDone:
+		return true
+	default:
+		return false
+	}
+}
+
+func (run *simulationRun) terminationProbe(wave int) TerminationProbeResponse {
+	// Read the passive state first so counters sampled afterwards include
+	// every message sent before becoming passive
+	passive := run.passive()
+	return TerminationProbeResponse{
+		Response: run.node.response(nil),
+		Wave:     wave,
+		Passive:  passive,
+		Sent:     run.eventsSent.Load() + run.nullMessagesSent.Load(),
+		Received: run.eventsReceived.Load() + run.nullMessagesReceived.Load(),
+	}
+}
+
+// TerminationWave aggregates the probe responses of every node in one wave
+type TerminationWave struct {
+	Passive  bool
+	Sent     uint64
+	Received uint64
+	Counters map[string][2]uint64
+}
+
+// NewTerminationWave returns an empty wave, passive until a node says otherwise
+func NewTerminationWave() *TerminationWave {
+	return &TerminationWave{
+		Passive:  true,
+		Counters: make(map[string][2]uint64),
+	}
+}
+
+// Add accounts the probe response of node in the wave
+func (tw *TerminationWave) Add(node string, probe TerminationProbeResponse) {
+	tw.Passive = tw.Passive && probe.Passive
+	tw.Sent += probe.Sent
+	tw.Received += probe.Received
+	tw.Counters[node] = [2]uint64{probe.Sent, probe.Received}
+}
+
+// Terminated reports whether the wave, following previous, proves global
+// termination.
+func (tw *TerminationWave) Terminated(previous *TerminationWave) bool {
+	if previous == nil || !tw.Passive || !previous.Passive || tw.Sent != tw.Received {
+		return false
+	}
+	if len(tw.Counters) != len(previous.Counters) {
+		return false
+	}
+	for node, counters := range tw.Counters {
+		if previous.Counters[node] != counters {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package dsim
+
+import "testing"
+
+func TestTerminationNeedsTwoEqualWaves(t *testing.T) {
+	first := NewTerminationWave()
+	first.Add("sn1", TerminationProbeResponse{Passive: true, Sent: 3, Received: 2})
+	first.Add("sn2", TerminationProbeResponse{Passive: true, Sent: 2, Received: 3})
+	if first.Terminated(nil) {
+		t.Fatalf("Terminated on the first wave")
+	}
+
+	second := NewTerminationWave()
+	second.Add("sn1", TerminationProbeResponse{Passive: true, Sent: 3, Received: 2})
+	second.Add("sn2", TerminationProbeResponse{Passive: true, Sent: 2, Received: 3})
+	if !second.Terminated(first) {
+		t.Fatalf("Did not terminate with two equal passive waves")
+	}
+}
+
+func TestTerminationWithMessagesInFlight(t *testing.T) {
+	first := NewTerminationWave()
+	first.Add("sn1", TerminationProbeResponse{Passive: true, Sent: 3, Received: 2})
+	first.Add("sn2", TerminationProbeResponse{Passive: true, Sent: 2, Received: 2})
+
+	second := NewTerminationWave()
+	second.Add("sn1", TerminationProbeResponse{Passive: true, Sent: 3, Received: 2})
+	second.Add("sn2", TerminationProbeResponse{Passive: true, Sent: 2, Received: 2})
+	if second.Terminated(first) {
+		t.Fatalf("Terminated with a message in flight")
+	}
+}
+
+func TestTerminationWithCountersChanged(t *testing.T) {
+	first := NewTerminationWave()
+	first.Add("sn1", TerminationProbeResponse{Passive: true, Sent: 2, Received: 2})
+	first.Add("sn2", TerminationProbeResponse{Passive: false, Sent: 2, Received: 2})
+
+	second := NewTerminationWave()
+	second.Add("sn1", TerminationProbeResponse{Passive: true, Sent: 2, Received: 3})
+	second.Add("sn2", TerminationProbeResponse{Passive: true, Sent: 3, Received: 2})
+	if second.Terminated(first) {
+		t.Fatalf("Terminated after an active wave")
+	}
+
+	third := NewTerminationWave()
+	third.Add("sn1", TerminationProbeResponse{Passive: true, Sent: 2, Received: 3})
+	third.Add("sn2", TerminationProbeResponse{Passive: true, Sent: 3, Received: 2})
+	if !third.Terminated(second) {
+		t.Fatalf("Did not terminate with two equal passive waves")
+	}
+}
@@ -0,0 +1,192 @@
+package dsim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Link is a directed link between two segments: the source sends events and
+// null messages to the destination, which waits on the source to advance.
+type Link struct {
+	Source      string
+	Destination string
+	// Arcs is the number of external propagation arcs behind the link
+	Arcs int
+}
+
+// Topology is the validated link graph of the segments of a run. It is built
+// by the launcher and sent to every node in PrepareSimulationRequest.
+type Topology struct {
+	// Segments maps segment names to the node hosting them
+	Segments map[string]TransitionNode
+	// Transitions maps global transition ids to the segment simulating them
+	Transitions map[TransitionId]string
+	// Links holds one link per pair of segments, sorted by source and
+	// destination
+	Links []Link
+}
+
+// TopologyError lists every problem found building or validating a topology
+type TopologyError struct {
+	Problems []string
+}
+
+func (e *TopologyError) Error() string {
+	return fmt.Sprintf("invalid topology: %s", strings.Join(e.Problems, "; "))
+}
+
+func (e *TopologyError) add(format string, a ...any) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, a...))
+}
+
+func (e *TopologyError) err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// NewTopology builds the topology of the segments in nodes, whose Name is the
+// segment name, simulating the lefs at the same position. Every external
+// propagation arc must reach a transition of another segment.
+func NewTopology(nodes []TransitionNode, lefs []Lefs) (*Topology, error) {
+	if len(nodes) != len(lefs) {
+		return nil, fmt.Errorf("%d segments given with %d lefs", len(nodes), len(lefs))
+	}
+	t := &Topology{
+		Segments:    make(map[string]TransitionNode, len(nodes)),
+		Transitions: make(map[TransitionId]string),
+		Links:       []Link{},
+	}
+	problems := &TopologyError{}
+	for i, node := range nodes {
+		if _, ok := t.Segments[node.Name]; ok {
+			problems.add("segment %s defined twice", node.Name)
+			continue
+		}
+		t.Segments[node.Name] = node
+		for _, id := range lefs[i].Network.sortedIds() {
+			if other, ok := t.Transitions[id]; ok {
+				problems.add("transition %d in segments %s and %s", id, other, node.Name)
+				continue
+			}
+			t.Transitions[id] = node.Name
+		}
+	}
+
+	arcs := make(map[[2]string]int)
+	for i, node := range nodes {
+		network := lefs[i].Network
+		for _, id := range network.sortedIds() {
+			transition := network[id]
+			if !transition.External {
+				continue
+			}
+			for _, p := range transition.Propagate {
+				if p.TransitionId >= 0 {
+					continue
+				}
+				remote := getLocalTransitionId(p.TransitionId)
+				destination, ok := t.Transitions[remote]
+				switch {
+				case !ok:
+					problems.add("transition %d of segment %s propagates to unknown transition %d", id, node.Name, remote)
+				case destination == node.Name:
+					problems.add("transition %d of segment %s propagates to transition %d of its own segment", id, node.Name, remote)
+				default:
+					arcs[[2]string{node.Name, destination}]++
+				}
+			}
+		}
+	}
+	for key, n := range arcs {
+		t.Links = append(t.Links, Link{Source: key[0], Destination: key[1], Arcs: n})
+	}
+	t.sortLinks()
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Topology) sortLinks() {
+	sort.Slice(t.Links, func(i, j int) bool {
+		a, b := t.Links[i], t.Links[j]
+		return a.Source < b.Source || a.Source == b.Source && a.Destination < b.Destination
+	})
+}
+
+// Validate checks a topology received from the launcher: every transition
+// and link endpoint must be a known segment and links cannot repeat or loop.
+func (t *Topology) Validate() error {
+	problems := &TopologyError{}
+	for name, node := range t.Segments {
+		if node.Name != name {
+			problems.add("segment %s hosted as %s", name, node.Name)
+		}
+	}
+	for id, segment := range t.Transitions {
+		if _, ok := t.Segments[segment]; !ok {
+			problems.add("transition %d in unknown segment %s", id, segment)
+		}
+	}
+	seen := make(map[[2]string]bool, len(t.Links))
+	for _, link := range t.Links {
+		key := [2]string{link.Source, link.Destination}
+		switch {
+		case seen[key]:
+			problems.add("link from %s to %s repeated", link.Source, link.Destination)
+		case link.Source == link.Destination:
+			problems.add("self-link on segment %s", link.Source)
+		}
+		seen[key] = true
+		for _, segment := range key {
+			if _, ok := t.Segments[segment]; !ok {
+				problems.add("link from %s to %s with unknown segment %s", link.Source, link.Destination, segment)
+			}
+		}
+	}
+	return problems.err()
+}
+
+// WaitingOn returns the segments sending to segment
+func (t *Topology) WaitingOn(segment string) []string {
+	sources := make([]string, 0)
+	for _, link := range t.Links {
+		if link.Destination == segment {
+			sources = append(sources, link.Source)
+		}
+	}
+	return sources
+}
+
+// Notifies returns the segments segment sends to
+func (t *Topology) Notifies(segment string) []TransitionNode {
+	destinations := make([]TransitionNode, 0)
+	for _, link := range t.Links {
+		if link.Source == segment {
+			destinations = append(destinations, t.Segments[link.Destination])
+		}
+	}
+	return destinations
+}
+
+// TransitionNodes maps every transition to the segment simulating it
+func (t *Topology) TransitionNodes() map[TransitionId]TransitionNode {
+	transitionNodes := make(map[TransitionId]TransitionNode, len(t.Transitions))
+	for id, segment := range t.Transitions {
+		transitionNodes[id] = t.Segments[segment]
+	}
+	return transitionNodes
+}
+
+// sortedIds returns the transition ids of the map in increasing order
+func (m TransitionMap) sortedIds() []TransitionId {
+	ids := make([]TransitionId, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,86 @@
+package dsim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testLefs builds a lefs whose transitions propagate to the remote
+// transitions in propagate, keyed by local transition id
+func testLefs(propagate map[TransitionId][]TransitionId) Lefs {
+	network := make(TransitionMap, len(propagate))
+	for id, remotes := range propagate {
+		t := &Transition{Id: id, External: len(remotes) > 0}
+		for _, remote := range remotes {
+			t.Propagate = append(t.Propagate, TransitionConstant{TransitionId: -(remote + 1)})
+		}
+		network[id] = t
+	}
+	return Lefs{Network: network}
+}
+
+func testSegments(names ...string) []TransitionNode {
+	nodes := make([]TransitionNode, len(names))
+	for i, name := range names {
+		nodes[i] = TransitionNode{Name: name, Node: "sn1"}
+	}
+	return nodes
+}
+
+func TestTopologyDeduplicatesLinks(t *testing.T) {
+	topology, err := NewTopology(testSegments("s0", "s1", "s2"), []Lefs{
+		testLefs(map[TransitionId][]TransitionId{0: {2, 3}, 1: {2}}),
+		testLefs(map[TransitionId][]TransitionId{2: {4}, 3: nil}),
+		testLefs(map[TransitionId][]TransitionId{4: {0}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Link{
+		{Source: "s0", Destination: "s1", Arcs: 3},
+		{Source: "s1", Destination: "s2", Arcs: 1},
+		{Source: "s2", Destination: "s0", Arcs: 1},
+	}
+	if !reflect.DeepEqual(topology.Links, want) {
+		t.Fatalf("Links = %+v, want %+v", topology.Links, want)
+	}
+	if got := topology.WaitingOn("s1"); !reflect.DeepEqual(got, []string{"s0"}) {
+		t.Fatalf("WaitingOn(s1) = %v", got)
+	}
+	if got := topology.Notifies("s0"); len(got) != 1 || got[0].Name != "s1" {
+		t.Fatalf("Notifies(s0) = %v", got)
+	}
+	if err := topology.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+}
+
+func TestTopologyRejectsUnresolvedAndSelfLinks(t *testing.T) {
+	_, err := NewTopology(testSegments("s0", "s1"), []Lefs{
+		testLefs(map[TransitionId][]TransitionId{0: {1}, 1: nil}),
+		testLefs(map[TransitionId][]TransitionId{2: {7}}),
+	})
+	var terr *TopologyError
+	if !errors.As(err, &terr) {
+		t.Fatalf("NewTopology() error = %v, want a TopologyError", err)
+	}
+	if len(terr.Problems) != 2 {
+		t.Fatalf("Problems = %q, want a self-link and an unknown transition", terr.Problems)
+	}
+}
+
+func TestTopologyValidate(t *testing.T) {
+	topology := &Topology{
+		Segments:    map[string]TransitionNode{"s0": {Name: "s0"}},
+		Transitions: map[TransitionId]string{0: "s0", 1: "s1"},
+		Links: []Link{
+			{Source: "s0", Destination: "s0", Arcs: 1},
+			{Source: "s0", Destination: "s0", Arcs: 1},
+		},
+	}
+	var terr *TopologyError
+	if err := topology.Validate(); !errors.As(err, &terr) || len(terr.Problems) != 3 {
+		t.Fatalf("Validate() = %v, want an unknown segment, a self-link and a repeated link", err)
+	}
+}
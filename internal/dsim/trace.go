@@ -0,0 +1,267 @@
+package dsim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+)
+
+// ActivityKind is the kind of an engine or link activity of a trace
+type ActivityKind string
+
+const (
+	// ActivityFire is the firing of a transition
+	ActivityFire ActivityKind = "fire"
+	// ActivityBlock is a period the engine waited in forwardTime for a
+	// segment it depends on
+	ActivityBlock ActivityKind = "block"
+	// ActivitySend is a message sent to a segment of another node
+	ActivitySend ActivityKind = "send"
+	// ActivityReceive is a message received from a segment of another node
+	ActivityReceive ActivityKind = "receive"
+)
+
+// Activity is something a segment did, traced live by a Tracer or read back
+// from the JSON logs of a run
+type Activity struct {
+	Kind    ActivityKind
+	Node    string
+	Run     RunId
+	Segment string
+	// Peer is the other segment of a message, or the segment waited on
+	Peer string
+	// Message is the type of a message, event or null
+	Message string
+	// Seq numbers the messages of a link, the send and receive of a message
+	// share it
+	Seq        uint64
+	Transition TransitionId
+	Clock      Clock
+	Start      time.Time
+	Duration   time.Duration
+}
+
+// activityFields returns the log fields that let an activity be read back
+// from a JSON log with ActivityFromRecord
+func activityFields(a Activity) clock.Fields {
+	fields := clock.Fields{
+		clock.FieldTrace:    string(a.Kind),
+		clock.FieldRun:      a.Run,
+		clock.FieldSegment:  a.Segment,
+		clock.FieldSimClock: a.Clock,
+	}
+	switch a.Kind {
+	case ActivityFire:
+		fields[clock.FieldTransition] = a.Transition
+	case ActivityBlock:
+		fields[clock.FieldPeer] = a.Peer
+		fields[clock.FieldDuration] = a.Duration.Seconds()
+	case ActivitySend, ActivityReceive:
+		fields[clock.FieldPeer] = a.Peer
+		fields[clock.FieldMessage] = a.Message
+		fields[clock.FieldSeq] = a.Seq
+	}
+	return fields
+}
+
+// ActivityFromRecord reads the activity logged with a JSON log record, false
+// when the record is not an activity
+func ActivityFromRecord(record clock.LogRecord) (Activity, bool) {
+	kind, _ := record.Fields[clock.FieldTrace].(string)
+	if kind == "" {
+		return Activity{}, false
+	}
+	a := Activity{
+		Kind:  ActivityKind(kind),
+		Node:  record.Pid,
+		Start: record.Time,
+	}
+	run, _ := record.Fields[clock.FieldRun].(string)
+	a.Run = RunId(run)
+	a.Segment, _ = record.Fields[clock.FieldSegment].(string)
+	a.Peer, _ = record.Fields[clock.FieldPeer].(string)
+	a.Message, _ = record.Fields[clock.FieldMessage].(string)
+	if v, ok := record.Fields[clock.FieldSimClock].(float64); ok {
+		a.Clock = Clock(v)
+	}
+	if v, ok := record.Fields[clock.FieldTransition].(float64); ok {
+		a.Transition = TransitionId(v)
+	}
+	if v, ok := record.Fields[clock.FieldSeq].(float64); ok {
+		a.Seq = uint64(v)
+	}
+	if v, ok := record.Fields[clock.FieldDuration].(float64); ok {
+		a.Duration = time.Duration(v * float64(time.Second))
+		// Blocks are logged once they end
+		a.Start = a.Start.Add(-a.Duration)
+	}
+	return a, true
+}
+
+// TraceEvent is an event of the Chrome Trace Event format, opened by
+// chrome://tracing and Perfetto
+type TraceEvent struct {
+	Name string `json:"name"`
+	Cat  string `json:"cat,omitempty"`
+	Ph   string `json:"ph"`
+	// Ts and Dur are microseconds
+	Ts    float64        `json:"ts"`
+	Dur   float64        `json:"dur,omitempty"`
+	Pid   uint32         `json:"pid"`
+	Tid   uint32         `json:"tid"`
+	ID    string         `json:"id,omitempty"`
+	Scope string         `json:"s,omitempty"`
+	BP    string         `json:"bp,omitempty"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+// traceId numbers the track of a node or segment, the same in every trace
+// so the traces of several nodes can be merged as they are
+func traceId(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()
+}
+
+// TrackEvents returns the metadata events naming the track of a segment
+// hosted by a node
+func TrackEvents(node, segment string) []TraceEvent {
+	pid, tid := traceId(node), traceId(segment)
+	return []TraceEvent{
+		{Name: "process_name", Ph: "M", Pid: pid, Tid: tid, Args: map[string]any{"name": node}},
+		{Name: "thread_name", Ph: "M", Pid: pid, Tid: tid, Args: map[string]any{"name": segment}},
+	}
+}
+
+// ActivityEvents converts an activity into trace events on the track of its
+// segment. Sends and receives are linked by a flow arrow.
+func ActivityEvents(a Activity) []TraceEvent {
+	pid, tid := traceId(a.Node), traceId(a.Segment)
+	ts := float64(a.Start.UnixNano()) / 1e3
+	dur := float64(a.Duration.Nanoseconds()) / 1e3
+	args := map[string]any{"run": a.Run, "clock": a.Clock}
+	switch a.Kind {
+	case ActivityFire:
+		args["transition"] = a.Transition
+		return []TraceEvent{{Name: fmt.Sprintf("fire %d", a.Transition), Cat: "transition", Ph: "i", Scope: "t", Ts: ts, Pid: pid, Tid: tid, Args: args}}
+	case ActivityBlock:
+		return []TraceEvent{{Name: "blocked on " + a.Peer, Cat: "blocked", Ph: "X", Ts: ts, Dur: dur, Pid: pid, Tid: tid, Args: args}}
+	case ActivitySend, ActivityReceive:
+		// Flow arrows need a slice to bind to
+		if dur < 1 {
+			dur = 1
+		}
+		source, destination := a.Segment, a.Peer
+		name, ph := fmt.Sprintf("send %s to %s", a.Message, a.Peer), "s"
+		if a.Kind == ActivityReceive {
+			source, destination = a.Peer, a.Segment
+			name, ph = fmt.Sprintf("receive %s from %s", a.Message, a.Peer), "f"
+		}
+		args["seq"] = a.Seq
+		id := fmt.Sprintf("%s/%s/%s/%d", a.Run, source, destination, a.Seq)
+		return []TraceEvent{
+			{Name: name, Cat: "message", Ph: "X", Ts: ts, Dur: dur, Pid: pid, Tid: tid, Args: args},
+			{Name: a.Message, Cat: "message", Ph: ph, Ts: ts, Pid: pid, Tid: tid, ID: id, BP: "e"},
+		}
+	}
+	return nil
+}
+
+// Tracer streams the activities of the segments of a node to a file in the
+// JSON Array Format of Chrome traces. A file left unterminated by a crash is
+// still accepted by the trace viewers.
+type Tracer struct {
+	mu     sync.Mutex
+	node   string
+	file   *os.File
+	w      *bufio.Writer
+	events int
+	tracks map[string]bool
+}
+
+// NewTracer creates the trace file of node
+func NewTracer(node, filename string) (*Tracer, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracer{node: node, file: f, w: bufio.NewWriter(f), tracks: make(map[string]bool)}
+	t.w.WriteString("[\n")
+	return t, nil
+}
+
+// record writes the trace events of an activity of the node
+func (t *Tracer) record(a Activity) {
+	a.Node = t.node
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.tracks[a.Segment] {
+		t.tracks[a.Segment] = true
+		t.write(TrackEvents(t.node, a.Segment))
+	}
+	t.write(ActivityEvents(a))
+}
+
+func (t *Tracer) write(events []TraceEvent) {
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		if t.events > 0 {
+			t.w.WriteString(",\n")
+		}
+		t.w.Write(data)
+		t.events++
+	}
+}
+
+// Close terminates and closes the trace file
+func (t *Tracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.WriteString("\n]\n")
+	if err := t.w.Flush(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
+
+// ReadTrace reads the events of a trace file in the JSON Array or Object
+// Format, completing an unterminated array
+func ReadTrace(r io.Reader) ([]TraceEvent, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var events []TraceEvent
+	if err := json.Unmarshal(data, &events); err == nil {
+		return events, nil
+	}
+	var object struct {
+		TraceEvents []TraceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(data, &object); err == nil {
+		return object.TraceEvents, nil
+	}
+	if err := json.Unmarshal(append(data, ']'), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// WriteTrace writes trace events in the JSON Object Format
+func WriteTrace(w io.Writer, events []TraceEvent) error {
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []TraceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
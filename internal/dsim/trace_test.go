@@ -0,0 +1,49 @@
+package dsim
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mursisoy/distributed-petri-net-simulator/internal/common/clock"
+)
+
+// logged returns the activity as read back from a JSON log record
+func logged(t *testing.T, node string, a Activity) Activity {
+	data, err := json.Marshal(clock.LogRecord{Time: a.Start.Add(a.Duration), Pid: node, Fields: activityFields(a)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record clock.LogRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	read, ok := ActivityFromRecord(record)
+	if !ok {
+		t.Fatalf("Activity %+v not read back", a)
+	}
+	return read
+}
+
+func TestActivityFromRecord(t *testing.T) {
+	start := time.UnixMilli(1000)
+	send := Activity{Kind: ActivitySend, Run: "r", Segment: "subred0", Peer: "subred1", Message: metricEvent, Seq: 3, Clock: 2, Start: start}
+	receive := Activity{Kind: ActivityReceive, Run: "r", Segment: "subred1", Peer: "subred0", Message: metricEvent, Seq: 3, Clock: 2, Start: start}
+	block := Activity{Kind: ActivityBlock, Run: "r", Segment: "subred1", Peer: "subred0", Start: start, Duration: time.Second}
+
+	if got := logged(t, "sn2", block); !got.Start.Equal(start) || got.Duration != time.Second || got.Peer != "subred0" {
+		t.Fatalf("Block read back as %+v", got)
+	}
+
+	flowStart := ActivityEvents(logged(t, "sn1", send))
+	flowEnd := ActivityEvents(logged(t, "sn2", receive))
+	if len(flowStart) != 2 || len(flowEnd) != 2 {
+		t.Fatalf("Message events %+v %+v", flowStart, flowEnd)
+	}
+	if flowStart[1].Ph != "s" || flowEnd[1].Ph != "f" || flowStart[1].ID != flowEnd[1].ID {
+		t.Fatalf("Send and receive not linked: %+v %+v", flowStart[1], flowEnd[1])
+	}
+	if flowStart[0].Pid == flowEnd[0].Pid {
+		t.Fatal("Nodes share a track")
+	}
+}
@@ -34,6 +34,9 @@ type Transition struct {
 	Lookahead []TransitionId
 
 	External bool `json:"ib_desalida"`
+
+	// numero de veces que se ha disparado la transicion
+	Fired uint64 `json:"ii_vecesdisparada"`
 }
 
 func (tc *TransitionConstant) UnmarshalJSON(buf []byte) error {